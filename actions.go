@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"time"
+)
+
+var errReadOnly = errors.New("sessions can't be killed with -read-only")
+
+// sessAction is a KILL or DISCONNECT SESSION waiting for the confirmation
+type sessAction struct {
+	disconnect  bool
+	inst        int
+	sid, serial string
+	user        string
+}
+
+func (a sessAction) target() string {
+	if a.inst > 0 {
+		return fmt.Sprintf("%s,%s,@%d", a.sid, a.serial, a.inst)
+	}
+	return a.sid + "," + a.serial
+}
+
+func (a sessAction) verb() string {
+	if a.disconnect {
+		return "DISCONNECT"
+	}
+	return "KILL"
+}
+
+// sql is the ALTER SYSTEM for a; sid and serial# are checked to be numbers
+// since the session can't be a bind variable. DISCONNECT has no @inst.
+func (a sessAction) sql() (string, error) {
+	if _, err := strconv.Atoi(a.sid); err != nil {
+		return "", fmt.Errorf("bad sid %q", a.sid)
+	}
+	if _, err := strconv.Atoi(a.serial); err != nil {
+		return "", fmt.Errorf("bad serial# %q", a.serial)
+	}
+	if a.disconnect {
+		return fmt.Sprintf("alter system disconnect session '%s,%s' immediate", a.sid, a.serial), nil
+	}
+	return fmt.Sprintf("alter system kill session '%s' immediate", a.target()), nil
+}
+
+func (a sessAction) prompt() string {
+	who := ""
+	if a.user != "" {
+		who = " of " + a.user
+	}
+	return fmt.Sprintf(" %s SESSION '%s'%s? y/N ", a.verb(), a.target(), who)
+}
+
+// run executes a confirmed action. Nothing is done unless the audit
+// file takes the line first; the result is logged after it.
+func (a sessAction) run(c Collector, audit string) string {
+	f, err := os.OpenFile(audit, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return "audit: " + err.Error()
+	}
+	defer f.Close()
+	osuser := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		osuser = u.Username
+	}
+	line := fmt.Sprintf("%s osuser=%s %s SESSION '%s'", time.Now().Format("2006-01-02 15:04:05"), osuser, a.verb(), a.target())
+	if a.user != "" {
+		line += " user=" + a.user
+	}
+	if _, err = fmt.Fprintln(f, line, "requested"); err != nil {
+		return "audit: " + err.Error()
+	}
+	res := "done"
+	if err = c.KillSession(a); err != nil {
+		res = err.Error()
+	}
+	fmt.Fprintln(f, line, "result="+res)
+	return a.verb() + " " + a.target() + ": " + res
+}
+
+// printPrompt asks on the bottom line
+func printPrompt(msg string, S map[string]F) {
+	scr := S["screen"]
+	fmt.Fprint(out, xy(1, scr.h), fg(231), bg(160), mark(fit(msg, scr.w-1)), fg(16), bg(255))
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ActivityRow is the DB time of a wait class in one bucket
+type ActivityRow struct {
+	Bucket     int64  `db:"BUCKET" json:"bucket"` // start, seconds since 1970 in database time
+	Wait_class string `db:"WAIT_CLASS" json:"wait_class"`
+	Seconds    int    `db:"SECONDS" json:"seconds"`
+}
+
+func ashActivity(db *sqlx.DB, sc ashScope, bucket int) ([]ActivityRow, error) {
+	var res []ActivityRow
+	b := fmt.Sprintf("trunc((cast(sample_time as date) - date '1970-01-01')*86400/%d)*%d", bucket, bucket)
+	err := db.Select(&res, `select `+b+` bucket, decode(session_state,'ON CPU','CPU',wait_class) wait_class, count(*)*:1 seconds
+	 from `+ashFrom(sc)+`
+	 group by `+b+`, decode(session_state,'ON CPU','CPU',wait_class)
+	 order by 1`, sampleSecs(sc))
+	return res, err
+}
+
+// bucketSecs picks the bucket length for an ASH window of minutes
+func bucketSecs(minutes int) int {
+	switch {
+	case minutes <= 15:
+		return 10
+	case minutes <= 120:
+		return 60
+	case minutes <= 12*60:
+		return 300
+	}
+	return 3600
+}
+
+// wait class colors, the way Enterprise Manager shows them
+var waitClassColors = []struct {
+	class string
+	color int
+}{
+	{"CPU", 34},
+	{"Scheduler", 120},
+	{"User I/O", 27},
+	{"System I/O", 75},
+	{"Concurrency", 88},
+	{"Application", 160},
+	{"Commit", 208},
+	{"Configuration", 94},
+	{"Administrative", 58},
+	{"Network", 180},
+	{"Queueing", 137},
+	{"Cluster", 250},
+	{"Other", 211},
+}
+
+// waitClassRank orders the stack, unknown classes go on top
+func waitClassRank(class string) int {
+	for i, wc := range waitClassColors {
+		if wc.class == class {
+			return i
+		}
+	}
+	return len(waitClassColors)
+}
+
+// waitClassGlyph stands for the color of class without colors
+func waitClassGlyph(class string) string {
+	const glyphs = "█▓▒░#%=+:-."
+	r := []rune(glyphs)
+	return string(r[waitClassRank(class)%len(r)])
+}
+
+func waitClassColor(class string) int {
+	if i := waitClassRank(class); i < len(waitClassColors) {
+		return waitClassColors[i].color
+	}
+	return 244
+}
+
+// printActivity draws the AAS of the last buckets stacked by wait class,
+// the newest on the right, with a line at the number of CPUs
+func printActivity(fr frame, S map[string]F) {
+	f, ok := S["activity"]
+	if !ok {
+		return
+	}
+	bucket := fr.Bucket
+	if bucket == 0 {
+		bucket = bucketSecs(fr.Window)
+	}
+
+	// AAS per bucket and wait class
+	aas := make(map[int64]map[string]float64)
+	seen := make(map[string]bool)
+	var last int64
+	for _, r := range fr.Activity {
+		if aas[r.Bucket] == nil {
+			aas[r.Bucket] = make(map[string]float64)
+		}
+		aas[r.Bucket][r.Wait_class] += float64(r.Seconds) / float64(bucket)
+		seen[r.Wait_class] = true
+		if r.Bucket > last {
+			last = r.Bucket
+		}
+	}
+	var classes []string
+	for wc := range seen {
+		classes = append(classes, wc)
+	}
+	sort.Slice(classes, func(i, j int) bool {
+		ri, rj := waitClassRank(classes[i]), waitClassRank(classes[j])
+		return ri < rj || ri == rj && classes[i] < classes[j]
+	})
+
+	// the scale fits the highest bucket and the CPU line
+	top := float64(fr.Metrics.cpus)
+	for _, m := range aas {
+		sum := 0.0
+		for _, v := range m {
+			sum += v
+		}
+		if sum > top {
+			top = sum
+		}
+	}
+	if top == 0 {
+		top = 1
+	}
+	cpuRow := -1
+	if fr.Metrics.cpus > 0 {
+		cpuRow = int(float64(fr.Metrics.cpus) / top * float64(f.h))
+		if cpuRow >= f.h {
+			cpuRow = f.h - 1
+		}
+	}
+
+	for r := 0; r < f.h; r++ {
+		var b strings.Builder
+		// the value in the middle of the row decides its color
+		level := (float64(f.h-1-r) + 0.5) / float64(f.h) * top
+		for c := 0; c < f.w; c++ {
+			bk := last - int64(f.w-1-c)*int64(bucket)
+			color := -1
+			ch := " "
+			if f.h-1-r == cpuRow {
+				ch = "─"
+			}
+			sum := 0.0
+			for _, wc := range classes {
+				sum += aas[bk][wc]
+				if level < sum {
+					color = waitClassColor(wc)
+					if depth == depthNone {
+						ch = waitClassGlyph(wc)
+					}
+					break
+				}
+			}
+			if color < 0 {
+				b.WriteString(bg(255) + ch)
+			} else {
+				b.WriteString(bg(color) + ch)
+			}
+		}
+		line := b.String()
+		if cpuRow >= 0 {
+			line = fg(160) + line + fg(16)
+		}
+		fmt.Fprint(out, xy(f.x, f.y+r), line, bg(255))
+	}
+	when := ""
+	if last > 0 {
+		when = ", last " + time.Unix(last, 0).UTC().Format("15:04:05")
+	}
+	title := fit(fmt.Sprintf(" AAS per %ds, max %.1f%s ", bucket, top, when), f.w-12)
+	fmt.Fprint(out, xy(f.x+10, f.y-1), fg(17), strings.TrimRight(title, " "), fg(16), strings.Repeat("─", len(title)-len(strings.TrimRight(title, " "))))
+
+	// legend, top of the stack first
+	lf := S["legend"]
+	var lines []string
+	if fr.Metrics.cpus > 0 {
+		lines = append(lines, fg(160)+"── "+fg(16)+fit(fmt.Sprintf("%d CPUs", fr.Metrics.cpus), lf.w-3))
+	}
+	for i := len(classes) - 1; i >= 0; i-- {
+		swatch := "  "
+		if depth == depthNone {
+			swatch = strings.Repeat(waitClassGlyph(classes[i]), 2)
+		}
+		lines = append(lines, bg(waitClassColor(classes[i]))+swatch+bg(255)+" "+fit(classes[i], lf.w-3))
+	}
+	for r := 0; r < lf.h; r++ {
+		l := strings.Repeat(" ", lf.w)
+		if r < len(lines) {
+			l = lines[r]
+		}
+		fmt.Fprint(out, xy(lf.x, lf.y+r), l)
+	}
+}
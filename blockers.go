@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// BlockerRow is a session which blocks or waits for another one
+type BlockerRow struct {
+	Inst_id                 int    `db:"INST_ID" json:"inst_id"`
+	Sid                     int    `db:"SID" json:"sid"`
+	Serial                  int    `db:"SERIAL#" json:"serial"`
+	Username                string `db:"USERNAME" json:"username"`
+	Status                  string `db:"STATUS" json:"status"`
+	Sql_id                  string `db:"SQL_ID" json:"sql_id"` // the previous one if there is no current
+	Event                   string `db:"EVENT" json:"event"`
+	Last_call_et            int    `db:"LAST_CALL_ET" json:"last_call_et"`
+	Seconds_in_wait         int    `db:"SECONDS_IN_WAIT" json:"seconds_in_wait"`
+	Blocking_instance       int    `db:"BLOCKING_INSTANCE" json:"blocking_instance,omitempty"`
+	Blocking_session        int    `db:"BLOCKING_SESSION" json:"blocking_session,omitempty"`
+	Final_blocking_instance int    `db:"FINAL_BLOCKING_INSTANCE" json:"final_blocking_instance,omitempty"`
+	Final_blocking_session  int    `db:"FINAL_BLOCKING_SESSION" json:"final_blocking_session,omitempty"`
+}
+
+// getBlockers reads the waiters and their blockers of the whole cluster, locks are global
+func getBlockers(db *sqlx.DB) ([]BlockerRow, error) {
+	var res []BlockerRow
+	err := db.Select(&res, `select inst_id, sid, serial#, nvl(username, '-') username, status,
+  nvl(nvl(sql_id, prev_sql_id), '-') sql_id, decode(state, 'WAITING', event, 'ON CPU') event,
+  last_call_et, seconds_in_wait,
+  nvl(blocking_instance, 0) blocking_instance, nvl(blocking_session, 0) blocking_session,
+  nvl(final_blocking_instance, 0) final_blocking_instance, nvl(final_blocking_session, 0) final_blocking_session
+from gv$session s
+where blocking_session is not null
+   or exists (select 1 from gv$session w where w.blocking_instance = s.inst_id and w.blocking_session = s.sid)`)
+	return res, err
+}
+
+type sessKey struct{ inst, sid int }
+
+func (r BlockerRow) key() sessKey {
+	return sessKey{r.Inst_id, r.Sid}
+}
+
+func (r BlockerRow) blocker() sessKey {
+	return sessKey{r.Blocking_instance, r.Blocking_session}
+}
+
+// blockerRoots returns the sessions which block others and wait for nobody,
+// the biggest pileup first
+func blockerRoots(rows []BlockerRow) []BlockerRow {
+	byKey := make(map[sessKey]BlockerRow)
+	for _, r := range rows {
+		byKey[r.key()] = r
+	}
+	var roots []BlockerRow
+	for _, r := range rows {
+		if _, waits := byKey[r.blocker()]; r.Blocking_session == 0 || !waits {
+			roots = append(roots, r)
+		}
+	}
+	n := waiterCounts(rows)
+	sort.Slice(roots, func(i, j int) bool { return n[roots[i].key()] > n[roots[j].key()] })
+	return roots
+}
+
+// waiterCounts counts the sessions waiting for each final blocker
+func waiterCounts(rows []BlockerRow) map[sessKey]int {
+	n := make(map[sessKey]int)
+	for _, r := range rows {
+		if r.Final_blocking_session != 0 {
+			n[sessKey{r.Final_blocking_instance, r.Final_blocking_session}]++
+		}
+	}
+	return n
+}
+
+// blockerLines draws the trees under the root blockers, the root sel marked.
+// at is the line of the root sel.
+func blockerLines(rows []BlockerRow, sel int) (lines []string, at int) {
+	if len(rows) == 0 {
+		return []string{"no blocked sessions"}, 0
+	}
+	waiters := make(map[sessKey][]BlockerRow)
+	for _, r := range rows {
+		if r.Blocking_session != 0 {
+			waiters[r.blocker()] = append(waiters[r.blocker()], r)
+		}
+	}
+	n := waiterCounts(rows)
+	seen := make(map[sessKey]bool)
+	var walk func(r BlockerRow, prefix string)
+	walk = func(r BlockerRow, prefix string) {
+		ws := waiters[r.key()]
+		for i, w := range ws {
+			if seen[w.key()] {
+				continue // a deadlock
+			}
+			seen[w.key()] = true
+			branch, next := "├─ ", "│  "
+			if i == len(ws)-1 {
+				branch, next = "└─ ", "   "
+			}
+			lines = append(lines, fmt.Sprintf("%s %-10s %-40s %6ds", fit(prefix+branch+sessName(w), 32), w.Username, fitdots(w.Event, 40), w.Seconds_in_wait))
+			walk(w, prefix+next)
+		}
+	}
+	lines = append(lines, fmt.Sprintf("  %-30s %-10s %-8s %8s  %-13s  %s", "ROOT BLOCKER / WAITER", "USER", "STATUS", "IDLE", "SQL_ID", "WAITERS"))
+	for i, r := range blockerRoots(rows) {
+		idle := "-"
+		if r.Status != "ACTIVE" {
+			idle = fmt.Sprintf("%ds", r.Last_call_et)
+		}
+		seen[r.key()] = true
+		mark := "  "
+		if i == sel {
+			mark, at = "> ", len(lines)
+		}
+		lines = append(lines, fmt.Sprintf("%s%-30s %-10s %-8s %8s  %-13s  %d", mark, sessName(r), r.Username, r.Status, idle, r.Sql_id, n[r.key()]))
+		walk(r, "    ")
+		lines = append(lines, "")
+	}
+	return lines, at
+}
+
+// clampRoot keeps sel on one of the root blockers
+func clampRoot(rows []BlockerRow, sel int) int {
+	if n := len(blockerRoots(rows)); sel >= n {
+		sel = n - 1
+	}
+	if sel < 0 {
+		sel = 0
+	}
+	return sel
+}
+
+// blockerAction is a kill or disconnect of the root blocker sel
+func blockerAction(rows []BlockerRow, sel int, disconnect bool) (sessAction, bool) {
+	roots := blockerRoots(rows)
+	if sel < 0 || sel >= len(roots) {
+		return sessAction{}, false
+	}
+	r := roots[sel]
+	return sessAction{disconnect, r.Inst_id, strconv.Itoa(r.Sid), strconv.Itoa(r.Serial), r.Username}, true
+}
+
+// sessName is sid,serial#,@inst the way ALTER SYSTEM KILL SESSION wants it
+func sessName(r BlockerRow) string {
+	return fmt.Sprintf("%d,%d,@%d", r.Sid, r.Serial, r.Inst_id)
+}
+
+// room for the blocked sessions message on the bottom border of the metrics box
+const blockedW = 28
+
+// printBlocked shows the number of blocked sessions on the metrics box
+func printBlocked(fr frame, S map[string]F) {
+	m := S["metrics"]
+	msg := ""
+	if n := len(fr.Blockers) - len(blockerRoots(fr.Blockers)); n > 0 {
+		msg = fmt.Sprintf(" %d BLOCKED, b for tree ", n)
+		if len(msg) > blockedW {
+			msg = fmt.Sprintf(" %d BLOCKED ", n)
+		}
+	}
+	if pad := blockedW - len(msg); pad > 0 {
+		msg = strings.Repeat("─", pad) + msg
+	}
+	fmt.Fprint(out, xy(m.w-2-blockedW, m.y+m.h+1), fg(160), msg, fg(16))
+}
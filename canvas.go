@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// canvas is a screen in memory. It follows the cursor positioning of xy()
+// and drops colors and other escapes, so the dashboard can be kept as plain text.
+type canvas struct {
+	w, h  int
+	cells [][]rune
+	x, y  int    // cursor, 0-based
+	esc   []byte // escape sequence read so far
+}
+
+func newCanvas(w, h int) *canvas {
+	cv := &canvas{w: w, h: h}
+	cv.clear()
+	return cv
+}
+
+func (cv *canvas) clear() {
+	cv.cells = make([][]rune, cv.h)
+	for i := range cv.cells {
+		cv.cells[i] = []rune(strings.Repeat(" ", cv.w))
+	}
+}
+
+func (cv *canvas) Write(p []byte) (int, error) {
+	for i := 0; i < len(p); {
+		b := p[i]
+		if cv.esc != nil {
+			cv.esc = append(cv.esc, b)
+			i++
+			// ESC [ params final
+			if len(cv.esc) > 2 && b >= 0x40 && b <= 0x7e {
+				cv.control(string(cv.esc[2:len(cv.esc)-1]), b)
+				cv.esc = nil
+			}
+			continue
+		}
+		if b == 0x1b {
+			cv.esc = []byte{b}
+			i++
+			continue
+		}
+		r, n := utf8.DecodeRune(p[i:])
+		i += n
+		switch r {
+		case '\n':
+			cv.x, cv.y = 0, cv.y+1
+		case '\r':
+			cv.x = 0
+		default:
+			if cv.y >= 0 && cv.y < cv.h && cv.x >= 0 && cv.x < cv.w {
+				cv.cells[cv.y][cv.x] = r
+			}
+			cv.x++
+		}
+	}
+	return len(p), nil
+}
+
+// control handles a CSI sequence; only moving the cursor and clearing matter
+func (cv *canvas) control(params string, final byte) {
+	switch final {
+	case 'H':
+		cv.x, cv.y = 0, 0
+		if yx := strings.Split(params, ";"); len(yx) == 2 {
+			y, _ := strconv.Atoi(yx[0])
+			x, _ := strconv.Atoi(yx[1])
+			cv.x, cv.y = x-1, y-1
+		}
+	case 'J':
+		if params == "2" {
+			cv.clear()
+		}
+	}
+}
+
+// String returns the lines without trailing blanks
+func (cv *canvas) String() string {
+	var lines []string
+	for _, l := range cv.cells {
+		lines = append(lines, strings.TrimRight(string(l), " "))
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
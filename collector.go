@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Collector is the source of everything the dashboard shows.
+// main calls Metrics first on every refresh, then the ASH queries.
+type Collector interface {
+	Metrics(sc ashScope) (instanceMetrics, error)
+	// Scope reports what the data collected for sc actually covers
+	Scope(sc ashScope) ashScope
+	TopSqlids(sc ashScope) ([]SqlidRow, error)
+	TopSids(sc ashScope) ([]SessionRow, error)
+	TopEvents(sc ashScope) ([]EventRow, error)
+	Sqls(sqlids []SqlidRow) ([]SqltextRow, error)
+	// SqlText is the full text of a statement
+	SqlText(inst int, sqlid string) (string, error)
+	// Activity is ASH by wait class in buckets of bucket seconds
+	Activity(sc ashScope, bucket int) ([]ActivityRow, error)
+	// Blockers are the sessions of the lock chains in the whole cluster
+	Blockers() ([]BlockerRow, error)
+	// PanelRows runs the query of a custom panel
+	PanelRows(name string, p customPanel, sc ashScope) ([][]interface{}, error)
+
+	// drill-down for the detail screens
+	SqlDetail(inst int, sqlid string, child int64) (SqlStats, []PlanRow, error)
+	// PlanLines is the plan of a cursor with the ASH time of sc per line
+	PlanLines(inst int, sqlid string, child int64, sc ashScope) ([]PlanRow, error)
+	SessionDetail(inst int, sid, serial string, sc ashScope) (SessionInfo, error)
+	EventSqls(event string, sc ashScope) ([]SqlidRow, error)
+
+	// KillSession runs ALTER SYSTEM KILL or DISCONNECT SESSION
+	KillSession(a sessAction) error
+
+	// Instances lists the inst_ids of the cluster and the one we are connected to
+	Instances() ([]int, int, error)
+
+	// Reconnect replaces a lost connection with a new one
+	Reconnect() error
+	Close() error
+}
+
+// ashScope narrows the ASH queries
+type ashScope struct {
+	minutes int // window length
+	rows    int // top-N
+	inst    int // inst_id, 0 for all instances, -1 for the one we are connected to
+	// an AWR range instead of the last minutes, minutes is its length then
+	from, to time.Time
+	secs     int // set by Scope if the data covers less than the minutes
+}
+
+func (sc ashScope) live() bool {
+	return sc.to.IsZero()
+}
+
+// seconds is the time the ASH rows of sc cover
+func (sc ashScope) seconds() int {
+	if sc.secs > 0 {
+		return sc.secs
+	}
+	return sc.minutes * 60
+}
+
+// instFilter is the gv$ view condition for sc.inst
+func instFilter(sc ashScope) string {
+	switch {
+	case sc.inst == 0:
+		return "1=1"
+	case sc.inst < 0:
+		return "inst_id = sys_context('userenv', 'instance')"
+	}
+	return fmt.Sprintf("inst_id = %d", sc.inst)
+}
+
+// nextInst cycles through the instances of ids and then all of them
+func nextInst(inst, cur int, ids []int) int {
+	if inst < 0 {
+		inst = cur
+	}
+	if inst == 0 {
+		return ids[0]
+	}
+	for i, id := range ids {
+		if id == inst && i+1 < len(ids) {
+			return ids[i+1]
+		}
+	}
+	return 0
+}
+
+// oracleCollector reads everything from a live instance
+type oracleCollector struct {
+	db        *sqlx.DB
+	constring string
+}
+
+func newOracleCollector(constring string) (*oracleCollector, error) {
+	db, err := sqlx.Open("goracle", constring)
+	if err != nil {
+		return nil, err
+	}
+	return &oracleCollector{db: db, constring: constring}, nil
+}
+
+func (c *oracleCollector) Reconnect() error {
+	db, err := sqlx.Open("goracle", c.constring)
+	if err != nil {
+		return err
+	}
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return err
+	}
+	c.db.Close()
+	c.db = db
+	return nil
+}
+
+func (c *oracleCollector) Metrics(sc ashScope) (instanceMetrics, error) {
+	return getMetrics(c.db, sc)
+}
+
+func (c *oracleCollector) Scope(sc ashScope) ashScope {
+	return sc
+}
+
+func (c *oracleCollector) TopSqlids(sc ashScope) ([]SqlidRow, error) {
+	return ashTopSqlids(c.db, sc)
+}
+
+func (c *oracleCollector) TopSids(sc ashScope) ([]SessionRow, error) {
+	return ashTopSids(c.db, sc)
+}
+
+func (c *oracleCollector) TopEvents(sc ashScope) ([]EventRow, error) {
+	return ashTopEvents(c.db, sc)
+}
+
+func (c *oracleCollector) Sqls(sqlids []SqlidRow) ([]SqltextRow, error) {
+	return getSqls(c.db, sqlids)
+}
+
+func (c *oracleCollector) SqlText(inst int, sqlid string) (string, error) {
+	return getSqlFulltext(c.db, inst, sqlid, true)
+}
+
+func (c *oracleCollector) Activity(sc ashScope, bucket int) ([]ActivityRow, error) {
+	return ashActivity(c.db, sc, bucket)
+}
+
+func (c *oracleCollector) Blockers() ([]BlockerRow, error) {
+	return getBlockers(c.db)
+}
+
+func (c *oracleCollector) PanelRows(name string, p customPanel, sc ashScope) ([][]interface{}, error) {
+	return c.queryPanel(p, sc)
+}
+
+func (c *oracleCollector) SqlDetail(inst int, sqlid string, child int64) (SqlStats, []PlanRow, error) {
+	st, err := getSqlStats(c.db, inst, sqlid, child)
+	if err != nil {
+		return st, nil, err
+	}
+	plan, err := getPlan(c.db, inst, sqlid, child)
+	return st, plan, err
+}
+
+func (c *oracleCollector) PlanLines(inst int, sqlid string, child int64, sc ashScope) ([]PlanRow, error) {
+	plan, err := getPlan(c.db, inst, sqlid, child)
+	if err != nil {
+		return nil, err
+	}
+	secs, err := ashPlanLines(c.db, inst, sqlid, child, sc)
+	for i := range plan {
+		plan[i].Seconds = secs[plan[i].Id]
+	}
+	return plan, err
+}
+
+func (c *oracleCollector) SessionDetail(inst int, sid, serial string, sc ashScope) (SessionInfo, error) {
+	si, err := getSessionInfo(c.db, inst, sid, serial)
+	if err != nil {
+		return si, err
+	}
+	if si.Events, err = ashSessionEvents(c.db, inst, sid, serial, sc); err != nil {
+		return si, err
+	}
+	if si.Sqls, err = ashSessionSqls(c.db, inst, sid, serial, sc); err != nil {
+		return si, err
+	}
+	si.Waits, err = getWaitHistory(c.db, inst, sid)
+	return si, err
+}
+
+func (c *oracleCollector) EventSqls(event string, sc ashScope) ([]SqlidRow, error) {
+	return ashEventSqls(c.db, event, sc)
+}
+
+func (c *oracleCollector) KillSession(a sessAction) error {
+	stmt, err := a.sql()
+	if err != nil {
+		return err
+	}
+	if a.disconnect && a.inst > 0 {
+		var cur int
+		if err = c.db.Get(&cur, "select to_number(sys_context('userenv', 'instance')) from dual"); err != nil {
+			return err
+		}
+		if cur != a.inst {
+			return fmt.Errorf("DISCONNECT works on the connected instance %d only", cur)
+		}
+	}
+	_, err = c.db.Exec(stmt)
+	return err
+}
+
+func (c *oracleCollector) Instances() ([]int, int, error) {
+	var ids []int
+	var cur int
+	if err := c.db.Select(&ids, "select inst_id from gv$instance order by inst_id"); err != nil {
+		return nil, 0, err
+	}
+	err := c.db.Get(&cur, "select to_number(sys_context('userenv', 'instance')) from dual")
+	return ids, cur, err
+}
+
+func (c *oracleCollector) Close() error {
+	return c.db.Close()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// config is the -config JSON file. Flags given on the command line win over it.
+type config struct {
+	Theme  string `json:"theme,omitempty"`
+	Colors string `json:"colors,omitempty"`
+	Layout string `json:"layout,omitempty"` // a layout file
+	// table panels filled by queries, by name; a layout places them
+	Panels map[string]customPanel `json:"panels,omitempty"`
+}
+
+// loadConfig reads and checks fname; the panels refresh with the dashboard
+// every interval at the most.
+func loadConfig(fname string, interval time.Duration) (config, error) {
+	var cfg config
+	b, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return cfg, err
+	}
+	if err = json.Unmarshal(b, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %v", fname, err)
+	}
+	for name, p := range cfg.Panels {
+		if p.Refresh != 0 && time.Duration(p.Refresh)*time.Second < interval {
+			return cfg, fmt.Errorf("%s: panel %q: a refresh of %ds is shorter than the -interval of %v, leave it out to refresh with the dashboard",
+				fname, name, p.Refresh, interval)
+		}
+		for i, c := range p.Columns {
+			if c.Format == "" {
+				continue
+			}
+			if _, err = formatVerb(c.Format); err != nil {
+				return cfg, fmt.Errorf("%s: panel %q, column %d: %v", fname, name, i+1, err)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// flagSet tells which flags were given on the command line
+func flagSet() map[string]bool {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	return set
+}
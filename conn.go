@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// errors which mean the session or the instance is gone
+var connLostErrs = []string{
+	"driver: bad connection", "ORA-03113", "ORA-03114", "ORA-03135", "ORA-01012",
+	"ORA-01033", "ORA-01034", "ORA-01089", "ORA-01092", "ORA-02396", "ORA-12170",
+	"ORA-12514", "ORA-12528", "ORA-12537", "ORA-12541", "ORA-12543", "ORA-28547",
+	"broken pipe", "connection reset",
+}
+
+func isConnLost(e string) bool {
+	for _, s := range connLostErrs {
+		if strings.Contains(e, s) {
+			return true
+		}
+	}
+	return false
+}
+
+const maxBackoff = time.Minute
+
+// connState tracks a lost connection and the reconnect backoff
+type connState struct {
+	lost    time.Time // zero while connected
+	retry   time.Time // next reconnect attempt
+	backoff time.Duration
+	startup string // instance startup time seen last
+	note    string // shown in the status line once
+}
+
+func (cs *connState) down() bool {
+	return !cs.lost.IsZero()
+}
+
+// failed starts or continues the backoff
+func (cs *connState) failed() {
+	now := time.Now()
+	if cs.lost.IsZero() {
+		cs.lost = now
+		cs.backoff = time.Second
+	} else if cs.backoff *= 2; cs.backoff > maxBackoff {
+		cs.backoff = maxBackoff
+	}
+	cs.retry = now.Add(cs.backoff)
+}
+
+// update looks at a refreshed frame; it returns true if the instance was restarted
+func (cs *connState) update(fr frame) bool {
+	for _, e := range fr.Errs {
+		if isConnLost(e) {
+			cs.failed()
+			return false
+		}
+	}
+	if cs.down() {
+		logerr(fmt.Sprintf("reconnected after %s", time.Since(cs.lost).Round(time.Second)))
+		cs.lost = time.Time{}
+	}
+	if _, ok := fr.Errs["metrics"]; ok || fr.Metrics.startup == "" {
+		return false
+	}
+	restarted := cs.startup != "" && cs.startup != fr.Metrics.startup
+	if restarted {
+		cs.note = "instance restarted at " + fr.Metrics.startup
+		logerr(cs.note)
+	}
+	cs.startup = fr.Metrics.startup
+	return restarted
+}
+
+// printBanner shows how long the connection is lost over the top of the screen
+func printBanner(cs *connState, S map[string]F) {
+	scr := S["screen"]
+	if cs.note != "" {
+		fmt.Fprint(out, xy(1, scr.h), fg(17), fit(cs.note, scr.w-1), fg(16))
+		cs.note = ""
+	}
+	if !cs.down() {
+		return
+	}
+	msg := fmt.Sprintf(" CONNECTION LOST %8s ago, next attempt in %4s ",
+		time.Since(cs.lost).Round(time.Second), time.Until(cs.retry).Round(time.Second))
+	x := (scr.w - len(msg)) / 2
+	if x < 1 {
+		x = 1
+	}
+	fmt.Fprint(out, xy(x, 1), fg(231), bg(160), mark(msg), fg(16), bg(255))
+}
+
+// resetDeltas forgets everything computed from the previous instance incarnation:
+// the sparklines and the rules start over from fr, the first frame of the new one
+func resetDeltas(fr frame) {
+	mhist.reset()
+	mhist.add(fr)
+	alarms.active, alarms.flash = nil, false
+	alarms.check(fr)
+}
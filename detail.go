@@ -0,0 +1,472 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type SqlStats struct {
+	Sql_id       string         `db:"SQL_ID"`
+	Child_number int64          `db:"CHILD_NUMBER"`
+	Plan         sql.NullInt64  `db:"PLAN_HASH_VALUE"`
+	Schema       sql.NullString `db:"PARSING_SCHEMA_NAME"`
+	Module       sql.NullString `db:"MODULE"`
+	Executions   int64          `db:"EXECUTIONS"`
+	Elapsed      int64          `db:"ELAPSED_TIME"`
+	Cpu          int64          `db:"CPU_TIME"`
+	Buffer_gets  int64          `db:"BUFFER_GETS"`
+	Disk_reads   int64          `db:"DISK_READS"`
+	Rows         int64          `db:"ROWS_PROCESSED"`
+	Last_active  sql.NullString `db:"LAST_ACTIVE"`
+	Sqltext      string         `db:"SQL_TEXT"`
+}
+
+type PlanRow struct {
+	Id          int            `db:"ID"`
+	Depth       int            `db:"DEPTH"`
+	Operation   string         `db:"OPERATION"`
+	Options     sql.NullString `db:"OPTIONS"`
+	Object_name sql.NullString `db:"OBJECT_NAME"`
+	Cost        sql.NullInt64  `db:"COST"`
+	Cardinality sql.NullInt64  `db:"CARDINALITY"`
+	Access      sql.NullString `db:"ACCESS_PREDICATES"`
+	Filter      sql.NullString `db:"FILTER_PREDICATES"`
+	Seconds     int            `db:"-"` // ASH time of the line, set by PlanLines
+}
+
+type SessionInfo struct {
+	Sid             string         `db:"SID"`
+	Serial          string         `db:"SERIAL#"`
+	Username        sql.NullString `db:"USERNAME"`
+	Status          sql.NullString `db:"STATUS"`
+	Osuser          sql.NullString `db:"OSUSER"`
+	Machine         sql.NullString `db:"MACHINE"`
+	Program         sql.NullString `db:"PROGRAM"`
+	Module          sql.NullString `db:"MODULE"`
+	Action          sql.NullString `db:"ACTION"`
+	Service         sql.NullString `db:"SERVICE_NAME"`
+	Sql_id          sql.NullString `db:"SQL_ID"`
+	Event           sql.NullString `db:"EVENT"`
+	Wait_class      sql.NullString `db:"WAIT_CLASS"`
+	State           sql.NullString `db:"STATE"`
+	Seconds_in_wait sql.NullInt64  `db:"SECONDS_IN_WAIT"`
+	Logon_time      sql.NullString `db:"LOGON_TIME"`
+	Last_call_et    sql.NullInt64  `db:"LAST_CALL_ET"`
+	Prev_sql_id     sql.NullString `db:"PREV_SQL_ID"`
+	Pga_used        sql.NullInt64  `db:"PGA_USED_MEM"`
+	Pga_alloc       sql.NullInt64  `db:"PGA_ALLOC_MEM"`
+	Pga_max         sql.NullInt64  `db:"PGA_MAX_MEM"`
+	Temp            int64          `db:"TEMP_BYTES"`
+
+	// ASH of the session over the window and its last waits
+	Events []EventRow `db:"-"`
+	Sqls   []SqlidRow `db:"-"`
+	Waits  []WaitRow  `db:"-"`
+}
+
+// WaitRow is a line of v$session_wait_history, seq# 1 is the most recent wait
+type WaitRow struct {
+	Seq        int    `db:"SEQ#"`
+	Event      string `db:"EVENT"`
+	Wait_micro int64  `db:"WAIT_TIME_MICRO"`
+	Cpu_micro  int64  `db:"TIME_SINCE_LAST_WAIT_MICRO"`
+}
+
+func getSqlStats(db *sqlx.DB, inst int, sqlid string, child int64) (SqlStats, error) {
+	var st SqlStats
+	err := db.QueryRowx(`select sql_id, child_number, plan_hash_value, parsing_schema_name, module,
+  executions, elapsed_time, cpu_time, buffer_gets, disk_reads, rows_processed,
+  to_char(last_active_time, 'YYYY-MM-DD HH24:MI:SS') last_active, sql_text
+from gv$sql where sql_id = :1 and child_number = :2 and inst_id = :3`, sqlid, child, inst).StructScan(&st)
+	return st, err
+}
+
+func getPlan(db *sqlx.DB, inst int, sqlid string, child int64) ([]PlanRow, error) {
+	var res []PlanRow
+	err := db.Select(&res, `select id, depth, operation, options, object_name, cost, cardinality,
+  access_predicates, filter_predicates
+from gv$sql_plan where sql_id = :1 and child_number = :2 and inst_id = :3 order by id`, sqlid, child, inst)
+	return res, err
+}
+
+func getSessionInfo(db *sqlx.DB, inst int, sid, serial string) (SessionInfo, error) {
+	var si SessionInfo
+	err := db.QueryRowx(`select s.sid, s.serial#, s.username, s.status, s.osuser, s.machine, s.program,
+  s.module, s.action, s.service_name, s.sql_id, s.event, s.wait_class, s.state, s.seconds_in_wait,
+  to_char(s.logon_time, 'YYYY-MM-DD HH24:MI:SS') logon_time, s.last_call_et, s.prev_sql_id,
+  p.pga_used_mem, p.pga_alloc_mem, p.pga_max_mem,
+  nvl((select sum(u.blocks*t.block_size) from gv$tempseg_usage u, dba_tablespaces t
+   where u.tablespace = t.tablespace_name and u.inst_id = s.inst_id and u.session_addr = s.saddr), 0) temp_bytes
+from gv$session s left join gv$process p on p.inst_id = s.inst_id and p.addr = s.paddr
+where s.sid = :1 and s.serial# = :2 and s.inst_id = :3`, sid, serial, inst).StructScan(&si)
+	return si, err
+}
+
+// ashSessionEvents is the TOP WAITS of one session
+func ashSessionEvents(db *sqlx.DB, inst int, sid, serial string, sc ashScope) ([]EventRow, error) {
+	var res []EventRow
+	err := db.Select(&res, `select * from
+	(select decode(session_state,'ON CPU',session_state,event) event, wait_class, count(*)*:1 seconds
+	 from `+ashFrom(sc)+`
+	 and session_id = :2 and session_serial# = :3 and inst_id = :4
+	 group by decode(session_state,'ON CPU',session_state,event), wait_class order by 3 desc
+	)
+	where rownum <= 8`, sampleSecs(sc), sid, serial, inst)
+	return res, err
+}
+
+// ashSessionSqls is the TOP SQL_ID of one session
+func ashSessionSqls(db *sqlx.DB, inst int, sid, serial string, sc ashScope) ([]SqlidRow, error) {
+	var res []SqlidRow
+	err := db.Select(&res, `select * from
+	(select inst_id, sql_id, sql_child_number, count(*)*:1 seconds
+	 from `+ashFrom(sc)+`
+	 and session_id = :2 and session_serial# = :3 and inst_id = :4
+	 group by inst_id, sql_id, sql_child_number order by 4 desc
+	)
+	where rownum <= 8`, sampleSecs(sc), sid, serial, inst)
+	return res, err
+}
+
+func getWaitHistory(db *sqlx.DB, inst int, sid string) ([]WaitRow, error) {
+	var res []WaitRow
+	err := db.Select(&res, `select seq#, event, wait_time_micro, time_since_last_wait_micro
+from gv$session_wait_history where sid = :1 and inst_id = :2 order by seq#`, sid, inst)
+	return res, err
+}
+
+// ashPlanLines is the ASH time of one cursor per sql_plan_line_id
+func ashPlanLines(db *sqlx.DB, inst int, sqlid string, child int64, sc ashScope) (map[int]int, error) {
+	var rows []struct {
+		Line    int `db:"SQL_PLAN_LINE_ID"`
+		Seconds int `db:"SECONDS"`
+	}
+	err := db.Select(&rows, `select sql_plan_line_id, count(*)*:1 seconds
+	 from `+ashFrom(sc)+`
+	 and sql_id = :2 and sql_child_number = :3 and inst_id = :4 and sql_plan_line_id is not null
+	 group by sql_plan_line_id`, sampleSecs(sc), sqlid, child, inst)
+	res := make(map[int]int)
+	for _, r := range rows {
+		res[r.Line] = r.Seconds
+	}
+	return res, err
+}
+
+// ashEventSqls is the per-SQL breakdown of one TOP WAITS line
+func ashEventSqls(db *sqlx.DB, event string, sc ashScope) ([]SqlidRow, error) {
+	var res []SqlidRow
+	err := db.Select(&res, `select * from
+	(select inst_id, sql_id, sql_child_number, count(*)*:1 seconds
+	 from `+ashFrom(sc)+`
+	 and decode(session_state,'ON CPU',session_state,event) = :2
+	 group by inst_id, sql_id, sql_child_number order by 4 desc
+	)
+	where rownum <= 20`, sampleSecs(sc), event)
+	return res, err
+}
+
+// top panels that can be navigated
+const (
+	panelSqlids = iota
+	panelSids
+	panelEvents
+)
+
+// selection is the highlighted row of the focused top panel
+type selection struct {
+	panel int
+	row   int
+}
+
+// at returns the highlighted row of panel or -1
+func (sel selection) at(panel int) int {
+	if sel.panel != panel {
+		return -1
+	}
+	return sel.row
+}
+
+// clamp keeps the highlight within the rows of the focused panel
+func (sel *selection) clamp(fr frame) {
+	n := 0
+	switch sel.panel {
+	case panelSqlids:
+		n = len(fr.Sqlids)
+	case panelSids:
+		n = len(fr.Sids)
+	case panelEvents:
+		n = len(fr.Events)
+	}
+	if sel.row >= n {
+		sel.row = n - 1
+	}
+	if sel.row < 0 {
+		sel.row = 0
+	}
+}
+
+// openDetail shows the detail screen for the selected row in a scrollable
+// view, nil if there is nothing selected.
+func openDetail(c Collector, sc ashScope, fr frame, sel selection) *textView {
+	var title string
+	var lines []string
+	switch sel.panel {
+	case panelSqlids:
+		if sel.row >= len(fr.Sqlids) {
+			return nil
+		}
+		r := fr.Sqlids[sel.row]
+		title = fmt.Sprintf("SQL_ID %s (%d) @%d", r.Sql_id.String, r.Sql_child_number.Int64, r.Inst_id)
+		st, plan, err := c.SqlDetail(r.Inst_id, r.Sql_id.String, r.Sql_child_number.Int64)
+		if err != nil {
+			lines = []string{err.Error()}
+		} else {
+			lines = sqlDetailLines(st, plan)
+		}
+	case panelSids:
+		return openSession(c, sc, fr, sel)
+	case panelEvents:
+		if sel.row >= len(fr.Events) {
+			return nil
+		}
+		r := fr.Events[sel.row]
+		title = "EVENT " + r.Event.String
+		rows, err := c.EventSqls(r.Event.String, sc)
+		if err != nil {
+			lines = []string{err.Error()}
+		} else {
+			lines = eventDetailLines(rows, fr.windowSecs(), fr.Cluster)
+		}
+	}
+	return newTextView(title, lines)
+}
+
+// openSession shows the selected session with its ASH and wait history
+// in a scrollable view, nil if there is no session selected.
+func openSession(c Collector, sc ashScope, fr frame, sel selection) *textView {
+	if sel.panel != panelSids || sel.row >= len(fr.Sids) {
+		return nil
+	}
+	r := fr.Sids[sel.row]
+	title := fmt.Sprintf("SESSION %s,%s,@%d", r.Sid.String, r.Serial.String, r.Inst_id)
+	si, err := c.SessionDetail(r.Inst_id, r.Sid.String, r.Serial.String, sc)
+	if err != nil {
+		return newTextView(title, []string{err.Error()})
+	}
+	return newTextView(title, sessionDetailLines(si, fr.windowSecs()))
+}
+
+// openPlan shows the plan of the selected SQL_ID with the ASH time per line
+// in a scrollable view, nil if there is no SQL_ID selected.
+func openPlan(c Collector, sc ashScope, fr frame, sel selection) *textView {
+	if sel.panel != panelSqlids || sel.row >= len(fr.Sqlids) || !fr.Sqlids[sel.row].Sql_id.Valid {
+		return nil
+	}
+	r := fr.Sqlids[sel.row]
+	title := fmt.Sprintf("PLAN OF SQL_ID %s (%d) @%d", r.Sql_id.String, r.Sql_child_number.Int64, r.Inst_id)
+	plan, err := c.PlanLines(r.Inst_id, r.Sql_id.String, r.Sql_child_number.Int64, c.Scope(sc))
+	if err != nil {
+		return newTextView(title, []string{err.Error()})
+	}
+	return newTextView(title, planLines(plan, fr.Window))
+}
+
+// showDetail replaces the dashboard with a text screen until a key is pressed
+func showDetail(title string, lines []string, S map[string]F) {
+	scr := S["screen"]
+	if len(lines) > scr.h-4 {
+		lines = lines[:scr.h-4]
+	}
+	fmt.Fprint(out, fg(16), bg(255), Cls, xy(1, 1))
+	fmt.Fprint(out, fg(17), BoldFont, title, fg(16))
+	for i, l := range lines {
+		fmt.Fprint(out, xy(1, i+3), fitdots(l, scr.w))
+	}
+	fmt.Fprint(out, xy(1, len(lines)+4), fg(17), "press any key to return", fg(16))
+}
+
+func nstr(s sql.NullString) string {
+	if !s.Valid {
+		return "-"
+	}
+	return s.String
+}
+
+func sqlDetailLines(st SqlStats, plan []PlanRow) []string {
+	per := func(v int64) string {
+		if st.Executions == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f", float64(v)/float64(st.Executions))
+	}
+	lines := []string{
+		fmt.Sprintf("Schema: %-20s Module: %-30s Plan HV: %d", nstr(st.Schema), nstr(st.Module), st.Plan.Int64),
+		fmt.Sprintf("Last active: %s", nstr(st.Last_active)),
+		"",
+		fmt.Sprintf("%-12s %15s %15s", "", "total", "per exec"),
+		fmt.Sprintf("%-12s %15d", "Executions", st.Executions),
+		fmt.Sprintf("%-12s %15.2f %15s", "Elapsed ms", float64(st.Elapsed)/1000, per(st.Elapsed/1000)),
+		fmt.Sprintf("%-12s %15.2f %15s", "CPU ms", float64(st.Cpu)/1000, per(st.Cpu/1000)),
+		fmt.Sprintf("%-12s %15d %15s", "Buffer gets", st.Buffer_gets, per(st.Buffer_gets)),
+		fmt.Sprintf("%-12s %15d %15s", "Disk reads", st.Disk_reads, per(st.Disk_reads)),
+		fmt.Sprintf("%-12s %15d %15s", "Rows", st.Rows, per(st.Rows)),
+		"",
+	}
+	lines = append(lines, wrap(trimsql(oneline(st.Sqltext)), 100)...)
+	lines = append(lines, "", fmt.Sprintf("%4s  %-70s %10s %10s", "Id", "Operation", "Rows", "Cost"))
+	for _, p := range plan {
+		op := strings.Repeat(" ", p.Depth) + p.Operation
+		if p.Options.Valid {
+			op += " " + p.Options.String
+		}
+		if p.Object_name.Valid {
+			op += " " + p.Object_name.String
+		}
+		lines = append(lines, fmt.Sprintf("%4d  %-70s %10s %10s", p.Id, op, nint(p.Cardinality), nint(p.Cost)))
+	}
+	return lines
+}
+
+// planLines is the plan laid out like DBMS_XPLAN with the share of ASH time per line
+func planLines(plan []PlanRow, window int) []string {
+	if len(plan) == 0 {
+		return []string{"the cursor is no longer in the shared pool"}
+	}
+	total := 0
+	for _, p := range plan {
+		total += p.Seconds
+	}
+	lines := []string{
+		fmt.Sprintf("ASH %ds on the plan lines in the %dm window", total, window),
+		"",
+		fmt.Sprintf(" %4s  %-50s %-30s %8s %8s  %s", "Id", "Operation", "Name", "Rows", "Cost", "ASH"),
+	}
+	var preds []string
+	for _, p := range plan {
+		star := " "
+		if p.Access.Valid || p.Filter.Valid {
+			star = "*"
+		}
+		if p.Access.Valid {
+			preds = append(preds, fmt.Sprintf("%4d - access(%s)", p.Id, p.Access.String))
+		}
+		if p.Filter.Valid {
+			preds = append(preds, fmt.Sprintf("%4d - filter(%s)", p.Id, p.Filter.String))
+		}
+		op := strings.Repeat(" ", p.Depth) + p.Operation
+		if p.Options.Valid {
+			op += " " + p.Options.String
+		}
+		ash := ""
+		if p.Seconds > 0 {
+			pct := p.Seconds * 100 / total
+			ash = fmt.Sprintf("%3d%% %s", pct, strings.Repeat("█", (pct+9)/10))
+		}
+		lines = append(lines, fmt.Sprintf("%s%4d  %-50s %-30s %8s %8s  %s",
+			star, p.Id, fitdots(op, 50), fitdots(p.Object_name.String, 30), nint(p.Cardinality), nint(p.Cost), ash))
+	}
+	if len(preds) > 0 {
+		lines = append(lines, "", "Predicate Information (identified by operation id):")
+		for _, l := range preds {
+			lines = append(lines, wrap(l, 120)...)
+		}
+	}
+	return lines
+}
+
+func nint(i sql.NullInt64) string {
+	if !i.Valid {
+		return ""
+	}
+	return fmt.Sprint(i.Int64)
+}
+
+func sessionDetailLines(si SessionInfo, secs int) []string {
+	mb := func(b sql.NullInt64) string {
+		if !b.Valid {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f MB", float64(b.Int64)/1048576)
+	}
+	sqlid := nstr(si.Sql_id)
+	if !si.Sql_id.Valid && si.Prev_sql_id.Valid {
+		sqlid = "- (previous " + si.Prev_sql_id.String + ")"
+	}
+	lines := []string{
+		fmt.Sprintf("%-16s %s", "Username:", nstr(si.Username)),
+		fmt.Sprintf("%-16s %s", "Status:", nstr(si.Status)),
+		fmt.Sprintf("%-16s %s", "OS user:", nstr(si.Osuser)),
+		fmt.Sprintf("%-16s %s", "Machine:", nstr(si.Machine)),
+		fmt.Sprintf("%-16s %s", "Program:", nstr(si.Program)),
+		fmt.Sprintf("%-16s %s", "Module:", nstr(si.Module)),
+		fmt.Sprintf("%-16s %s", "Action:", nstr(si.Action)),
+		fmt.Sprintf("%-16s %s", "Service:", nstr(si.Service)),
+		fmt.Sprintf("%-16s %s", "Logon time:", nstr(si.Logon_time)),
+		fmt.Sprintf("%-16s %ss", "Last call:", nint(si.Last_call_et)),
+		fmt.Sprintf("%-16s %s", "SQL_ID:", sqlid),
+		fmt.Sprintf("%-16s %s", "State:", nstr(si.State)),
+		fmt.Sprintf("%-16s %s (%s) %ss", "Event:", nstr(si.Event), nstr(si.Wait_class), nint(si.Seconds_in_wait)),
+		fmt.Sprintf("%-16s %s used, %s allocated, %s max", "PGA:", mb(si.Pga_used), mb(si.Pga_alloc), mb(si.Pga_max)),
+		fmt.Sprintf("%-16s %s", "Temp:", mb(sql.NullInt64{Int64: si.Temp, Valid: true})),
+		"",
+		fmt.Sprintf("%-56s  %s", "ASH BY EVENT", "ASH BY SQL_ID (child#)"),
+	}
+	for i := 0; i < len(si.Events) || i < len(si.Sqls); i++ {
+		ev, sq := "", ""
+		if i < len(si.Events) {
+			r := si.Events[i]
+			ev = fmt.Sprintf("%4d%% | %-32s %-14s", r.Seconds*100/secs, fitdots(r.Event.String, 32), r.Wait_class.String)
+		}
+		if i < len(si.Sqls) {
+			r := si.Sqls[i]
+			id := "(no sql_id)"
+			if r.Sql_id.Valid {
+				id = fmt.Sprintf("%s (%d)", r.Sql_id.String, r.Sql_child_number.Int64)
+			}
+			sq = fmt.Sprintf("%4d%% | %s", r.Seconds*100/secs, id)
+		}
+		lines = append(lines, fmt.Sprintf("%-56s  %s", ev, sq))
+	}
+	lines = append(lines, "", fmt.Sprintf("%-40s %12s %14s", "WAIT HISTORY, LATEST FIRST", "waited ms", "CPU before ms"))
+	for _, w := range si.Waits {
+		lines = append(lines, fmt.Sprintf("%-40s %12.3f %14.3f", fitdots(w.Event, 40), float64(w.Wait_micro)/1000, float64(w.Cpu_micro)/1000))
+	}
+	return lines
+}
+
+func eventDetailLines(rows []SqlidRow, secs int, cluster bool) []string {
+	lines := []string{fmt.Sprintf("%5s   %-20s %8s", "%", "SQL_ID (child#)", "seconds")}
+	if cluster {
+		lines[0] += "  INST"
+	}
+	for _, r := range rows {
+		id := "(no sql_id)"
+		if r.Sql_id.Valid {
+			id = fmt.Sprintf("%s (%d)", r.Sql_id.String, r.Sql_child_number.Int64)
+		}
+		l := fmt.Sprintf("%4d%%   %-20s %8d", r.Seconds*100/secs, id, r.Seconds)
+		if cluster {
+			l += fmt.Sprintf("  %4d", r.Inst_id)
+		}
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+// oneline turns line breaks and tabs into spaces
+func oneline(s string) string {
+	return strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ", "\t", " ").Replace(s)
+}
+
+// wrap splits s into lines at most w wide
+func wrap(s string, w int) []string {
+	var res []string
+	for len(s) > w {
+		res = append(res, s[:w])
+		s = s[w:]
+	}
+	return append(res, s)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// promMetric is an instanceMetrics field exported as a gauge
+type promMetric struct {
+	name string
+	help string
+	val  func(im instanceMetrics) float32
+}
+
+var promMetrics = []promMetric{
+	{"cpu_util_percent", "Host CPU Utilization (%)", func(im instanceMetrics) float32 { return im.cpuutil }},
+	{"db_cpu_time_ratio", "Database CPU Time Ratio", func(im instanceMetrics) float32 { return im.cpuratio }},
+	{"average_active_sessions", "Average Active Sessions", func(im instanceMetrics) float32 { return im.aas }},
+	{"executions_per_second", "Executions Per Sec", func(im instanceMetrics) float32 { return im.execs }},
+	{"user_calls_per_second", "User Calls Per Sec", func(im instanceMetrics) float32 { return im.calls }},
+	{"user_transactions_per_second", "User Transaction Per Sec", func(im instanceMetrics) float32 { return im.tnxs }},
+	{"logical_reads_per_second", "Logical Reads Per Sec", func(im instanceMetrics) float32 { return im.lios }},
+	{"physical_reads_per_second", "Physical Reads Per Sec", func(im instanceMetrics) float32 { return im.phyrd }},
+	{"physical_writes_per_second", "Physical Writes Per Sec", func(im instanceMetrics) float32 { return im.phywr }},
+	{"db_block_gets_per_second", "DB Block Gets Per Sec", func(im instanceMetrics) float32 { return im.blkgets }},
+	{"db_block_changes_per_second", "DB Block Changes Per Sec", func(im instanceMetrics) float32 { return im.blkchng }},
+	{"redo_bytes_per_second", "Redo Generated Per Sec", func(im instanceMetrics) float32 { return im.redomb }},
+	{"full_index_scans_per_second", "Full Index Scans Per Sec", func(im instanceMetrics) float32 { return im.fullindscan }},
+	{"total_index_scans_per_second", "Total Index Scans Per Sec", func(im instanceMetrics) float32 { return im.totindscan }},
+	{"total_table_scans_per_second", "Total Table Scans Per Sec", func(im instanceMetrics) float32 { return im.tottabscan }},
+}
+
+// exporter keeps the last collected round rendered in the Prometheus text format
+type exporter struct {
+	mu   sync.Mutex
+	text []byte
+}
+
+func (e *exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	text := e.text
+	e.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(text)
+}
+
+// instRound is what is exported for one instance
+type instRound struct {
+	up      bool
+	metrics instanceMetrics
+	events  []EventRow
+	secs    int
+}
+
+// serveMetrics runs the collector every interval and serves the results on /metrics.
+// Every instance of a cluster gets its own series; it returns only if the listener fails.
+func serveMetrics(c Collector, sc ashScope, addr string, interval time.Duration, top int) error {
+	e := &exporter{}
+	// the last instance_name seen per inst_id labels the instances which are down
+	names := make(map[int]string)
+	// the inst_ids of the last round, they are exported as down if the next one can't list them
+	var known []int
+	collect := func() {
+		var round []instRound
+		scopes := []ashScope{sc}
+		lost := false
+		ids, _, err := c.Instances()
+		down := err != nil && len(known) > 0
+		if err != nil {
+			logerr("instances: " + err.Error())
+			lost = isConnLost(err.Error())
+		}
+		if down {
+			ids = known
+		} else if len(ids) > 0 {
+			known = ids
+		}
+		if len(ids) > 0 {
+			scopes = nil
+			for _, id := range ids {
+				s := sc
+				s.inst = id
+				scopes = append(scopes, s)
+			}
+		}
+		for _, s := range scopes {
+			var ir instRound
+			// all events, the wait classes are summed up from them
+			s.rows = 1000
+			if !down {
+				ir.metrics, err = c.Metrics(s)
+			}
+			if err == nil && ir.metrics.iname != "?" {
+				names[s.inst] = ir.metrics.iname
+			} else if n, ok := names[s.inst]; ok {
+				ir.metrics.iname = n
+			} else {
+				ir.metrics.iname = fmt.Sprintf("inst_id %d", s.inst)
+			}
+			ir.secs = c.Scope(s).seconds()
+			if err == nil {
+				ir.events, err = c.TopEvents(s)
+			}
+			if err != nil && !down {
+				logerr(ir.metrics.iname + ": " + err.Error())
+				lost = lost || isConnLost(err.Error())
+			}
+			ir.up = err == nil
+			round = append(round, ir)
+		}
+		text := promText(round, top)
+		e.mu.Lock()
+		e.text = text
+		e.mu.Unlock()
+		if lost {
+			if err := c.Reconnect(); err != nil {
+				logerr("reconnect: " + err.Error())
+			}
+		}
+	}
+	collect()
+	go func() {
+		for range time.Tick(interval) {
+			collect()
+		}
+	}()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	log.Printf("serving metrics on %s", addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+// promText renders a round, top is the number of events exported per instance
+func promText(round []instRound, top int) []byte {
+	var b bytes.Buffer
+	gauge := func(name, help string) {
+		fmt.Fprintf(&b, "# HELP oradash_%s %s\n# TYPE oradash_%s gauge\n", name, help, name)
+	}
+	gauge("up", "Whether the last collection of the instance succeeded")
+	for _, ir := range round {
+		up := 0
+		if ir.up {
+			up = 1
+		}
+		fmt.Fprintf(&b, "oradash_up{instance_name=\"%s\"} %d\n", promLabel(ir.metrics.iname), up)
+	}
+	for _, m := range promMetrics {
+		gauge(m.name, m.help)
+		for _, ir := range round {
+			if ir.up {
+				fmt.Fprintf(&b, "oradash_%s{instance_name=\"%s\"} %g\n", m.name, promLabel(ir.metrics.iname), m.val(ir.metrics))
+			}
+		}
+	}
+
+	gauge("ash_wait_class_active_sessions", "Average active sessions per wait class over the ASH window")
+	for _, ir := range round {
+		if !ir.up || ir.secs == 0 {
+			continue
+		}
+		byClass := make(map[string]int)
+		for _, ev := range ir.events {
+			byClass[eventClass(ev)] += ev.Seconds
+		}
+		var classes []string
+		for wc := range byClass {
+			classes = append(classes, wc)
+		}
+		sort.Strings(classes)
+		for _, wc := range classes {
+			fmt.Fprintf(&b, "oradash_ash_wait_class_active_sessions{instance_name=\"%s\",wait_class=\"%s\"} %g\n",
+				promLabel(ir.metrics.iname), promLabel(wc), float64(byClass[wc])/float64(ir.secs))
+		}
+	}
+	gauge("ash_event_active_sessions", "Average active sessions of the top events over the ASH window")
+	for _, ir := range round {
+		if !ir.up || ir.secs == 0 {
+			continue
+		}
+		for i, ev := range ir.events {
+			if i == top {
+				break
+			}
+			fmt.Fprintf(&b, "oradash_ash_event_active_sessions{instance_name=\"%s\",event=\"%s\",wait_class=\"%s\"} %g\n",
+				promLabel(ir.metrics.iname), promLabel(ev.Event.String), promLabel(eventClass(ev)), float64(ev.Seconds)/float64(ir.secs))
+		}
+	}
+	return b.Bytes()
+}
+
+// eventClass is the wait class of an ASH event, CPU for ON CPU
+func eventClass(ev EventRow) string {
+	if !ev.Wait_class.Valid {
+		return "CPU"
+	}
+	return ev.Wait_class.String
+}
+
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promLabel escapes a label value for the text format
+func promLabel(s string) string {
+	return promEscaper.Replace(s)
+}
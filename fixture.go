@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+)
+
+var errNoDetail = errors.New("no details in a fixture or recording")
+var errNoSessions = errors.New("no sessions to kill in a fixture or recording")
+
+// frame is everything collected during one refresh.
+// Fixture files are JSON lines, one frame per line.
+type frame struct {
+	Time    time.Time       `json:"time"`
+	Metrics instanceMetrics `json:"metrics"`
+	Window  int             `json:"window"`            // ASH window, minutes
+	Secs    int             `json:"secs,omitempty"`    // the seconds sampled if less than the window
+	Cluster bool            `json:"cluster,omitempty"` // all instances aggregated
+	From    *time.Time      `json:"from,omitempty"`    // AWR range, nil for the live ASH
+	To      *time.Time      `json:"to,omitempty"`
+	Sqlids  []SqlidRow      `json:"sqlids"`
+	Sids    []SessionRow    `json:"sids"`
+	Events  []EventRow      `json:"events"`
+	Sqls    []SqltextRow    `json:"sqls"`
+	// AAS per wait class in buckets of Bucket seconds
+	Activity []ActivityRow `json:"activity,omitempty"`
+	Bucket   int           `json:"bucket,omitempty"`
+	Blockers []BlockerRow  `json:"blockers,omitempty"`
+	// rows of the custom panels by name
+	Panels map[string][][]interface{} `json:"panels,omitempty"`
+	// failed panels (keys from errPanels), their data is from the previous frame
+	Errs map[string]string `json:"errors,omitempty"`
+}
+
+// windowSecs is the time the ASH rows of the frame cover
+func (fr frame) windowSecs() int {
+	if fr.Secs > 0 {
+		return fr.Secs
+	}
+	return fr.Window * 60
+}
+
+// fixtureCollector serves frames loaded from a file instead of a database.
+// Every Metrics call moves to the next frame, wrapping around at the end
+// unless once is set. Errors recorded in a frame are returned by the
+// matching method, so failures replay the way they happened.
+type fixtureCollector struct {
+	frames []frame
+	idx    int
+	once   bool
+}
+
+func newFixtureCollector(fname string) (*fixtureCollector, error) {
+	frames, err := readFrames(fname)
+	if err != nil {
+		return nil, err
+	}
+	if len(frames) == 0 {
+		return nil, errors.New(fname + ": no frames")
+	}
+	return &fixtureCollector{frames: frames, idx: -1}, nil
+}
+
+func readFrames(fname string) ([]frame, error) {
+	var frames []frame
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var fr frame
+		if err := json.Unmarshal(sc.Bytes(), &fr); err != nil {
+			return frames, err
+		}
+		frames = append(frames, fr)
+	}
+	return frames, sc.Err()
+}
+
+func (c *fixtureCollector) cur() *frame {
+	if c.idx < 0 {
+		return &c.frames[0]
+	}
+	return &c.frames[c.idx]
+}
+
+func (c *fixtureCollector) Metrics(sc ashScope) (instanceMetrics, error) {
+	if !c.once || c.idx+1 < len(c.frames) {
+		c.idx = (c.idx + 1) % len(c.frames)
+	}
+	im := c.cur().Metrics
+	if im.mtime == "" {
+		im.mtime = time.Now().Format("15:04:05")
+	}
+	return im, c.err("metrics")
+}
+
+func (c *fixtureCollector) err(panel string) error {
+	if e, ok := c.cur().Errs[panel]; ok {
+		return errors.New(e)
+	}
+	return nil
+}
+
+// Scope is the one the frame was recorded with; the ASH rows can't be rescaled
+func (c *fixtureCollector) Scope(sc ashScope) ashScope {
+	if w := c.cur().Window; w > 0 {
+		sc.minutes, sc.secs = w, c.cur().Secs
+	}
+	if c.cur().Cluster {
+		sc.inst = 0
+	} else if sc.inst == 0 {
+		sc.inst = -1
+	}
+	sc.from, sc.to = time.Time{}, time.Time{}
+	if fr := c.cur(); fr.From != nil && fr.To != nil {
+		sc.from, sc.to = *fr.From, *fr.To
+	}
+	return sc
+}
+
+func (c *fixtureCollector) TopSqlids(sc ashScope) ([]SqlidRow, error) {
+	return c.cur().Sqlids, c.err("topsqlids")
+}
+
+func (c *fixtureCollector) TopSids(sc ashScope) ([]SessionRow, error) {
+	return c.cur().Sids, c.err("topsids")
+}
+
+func (c *fixtureCollector) TopEvents(sc ashScope) ([]EventRow, error) {
+	return c.cur().Events, c.err("events")
+}
+
+// Sqls behaves like gv$sql: only the cursors present in the frame are
+// returned, a row without a child_number is any child of its sql_id
+func (c *fixtureCollector) Sqls(sqlids []SqlidRow) ([]SqltextRow, error) {
+	var res []SqltextRow
+	if err := c.err("sqltext"); err != nil {
+		return res, err
+	}
+	for _, sqlid := range sqlids {
+		for _, r := range c.cur().Sqls {
+			if sqlid.Sql_id.Valid && r.Sql_id == sqlid.Sql_id.String && r.Inst_id == sqlid.Inst_id &&
+				(!r.Child_number.Valid || r.Child_number.Int64 == sqlid.Sql_child_number.Int64) {
+				res = append(res, r)
+				break
+			}
+		}
+	}
+	return res, nil
+}
+
+// SqlText is the (truncated) text recorded with the frame
+func (c *fixtureCollector) SqlText(inst int, sqlid string) (string, error) {
+	for _, r := range c.cur().Sqls {
+		if r.Sql_id == sqlid {
+			return r.Sqltext, nil
+		}
+	}
+	return "", errNoDetail
+}
+
+func (c *fixtureCollector) Activity(sc ashScope, bucket int) ([]ActivityRow, error) {
+	return c.cur().Activity, c.err("activity")
+}
+
+func (c *fixtureCollector) Blockers() ([]BlockerRow, error) {
+	return c.cur().Blockers, c.err("blockers")
+}
+
+// PanelRows are the rows recorded for the panel, a fixture runs no SQL
+func (c *fixtureCollector) PanelRows(name string, p customPanel, sc ashScope) ([][]interface{}, error) {
+	return c.cur().Panels[name], c.err("panel." + name)
+}
+
+// drill-down is not part of the fixture format
+
+func (c *fixtureCollector) SqlDetail(inst int, sqlid string, child int64) (SqlStats, []PlanRow, error) {
+	return SqlStats{}, nil, errNoDetail
+}
+
+func (c *fixtureCollector) PlanLines(inst int, sqlid string, child int64, sc ashScope) ([]PlanRow, error) {
+	return nil, errNoDetail
+}
+
+func (c *fixtureCollector) SessionDetail(inst int, sid, serial string, sc ashScope) (SessionInfo, error) {
+	return SessionInfo{}, errNoDetail
+}
+
+func (c *fixtureCollector) EventSqls(event string, sc ashScope) ([]SqlidRow, error) {
+	return nil, errNoDetail
+}
+
+// Instances is empty, a fixture can't switch instances
+func (c *fixtureCollector) Instances() ([]int, int, error) {
+	return nil, 0, nil
+}
+
+func (c *fixtureCollector) KillSession(a sessAction) error {
+	return errNoSessions
+}
+
+func (c *fixtureCollector) Reconnect() error {
+	return nil
+}
+
+func (c *fixtureCollector) Close() error {
+	return nil
+}
+
+// JSON encoding of the collected rows. NULL columns are written as null.
+
+func strPtr(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
+
+func intPtr(i sql.NullInt64) *int64 {
+	if !i.Valid {
+		return nil
+	}
+	return &i.Int64
+}
+
+func nullStr(s *string) sql.NullString {
+	if s == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: *s, Valid: true}
+}
+
+func nullInt(i *int64) sql.NullInt64 {
+	if i == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: *i, Valid: true}
+}
+
+type metricsJSON struct {
+	Iname       string  `json:"iname"`
+	Mtime       string  `json:"mtime"`
+	Startup     string  `json:"startup,omitempty"`
+	Cpus        int     `json:"cpus,omitempty"`
+	Inst        int     `json:"inst_id,omitempty"`
+	Cpuutil     float32 `json:"cpuutil"`
+	Cpuratio    float32 `json:"cpuratio"`
+	Aas         float32 `json:"aas"`
+	Execs       float32 `json:"execs"`
+	Calls       float32 `json:"calls"`
+	Tnxs        float32 `json:"tnxs"`
+	Lios        float32 `json:"lios"`
+	Phyrd       float32 `json:"phyrd"`
+	Phywr       float32 `json:"phywr"`
+	Blkgets     float32 `json:"blkgets"`
+	Blkchng     float32 `json:"blkchng"`
+	Redomb      float32 `json:"redomb"`
+	Fullindscan float32 `json:"fullindscan"`
+	Totindscan  float32 `json:"totindscan"`
+	Tottabscan  float32 `json:"tottabscan"`
+}
+
+func (im instanceMetrics) MarshalJSON() ([]byte, error) {
+	return json.Marshal(metricsJSON{
+		im.iname, im.mtime, im.startup, im.cpus, im.inst, im.cpuutil, im.cpuratio, im.aas, im.execs, im.calls, im.tnxs,
+		im.lios, im.phyrd, im.phywr, im.blkgets, im.blkchng, im.redomb,
+		im.fullindscan, im.totindscan, im.tottabscan,
+	})
+}
+
+func (im *instanceMetrics) UnmarshalJSON(b []byte) error {
+	var j metricsJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	*im = instanceMetrics{
+		j.Iname, j.Mtime, j.Startup, j.Cpus, j.Inst, j.Cpuutil, j.Cpuratio, j.Aas, j.Execs, j.Calls, j.Tnxs,
+		j.Lios, j.Phyrd, j.Phywr, j.Blkgets, j.Blkchng, j.Redomb,
+		j.Fullindscan, j.Totindscan, j.Tottabscan,
+	}
+	return nil
+}
+
+type sqlidJSON struct {
+	Inst_id          int     `json:"inst_id,omitempty"`
+	Sql_id           *string `json:"sql_id"`
+	Sql_child_number *int64  `json:"child_number"`
+	Seconds          int     `json:"seconds"`
+}
+
+func (r SqlidRow) MarshalJSON() ([]byte, error) {
+	return json.Marshal(sqlidJSON{r.Inst_id, strPtr(r.Sql_id), intPtr(r.Sql_child_number), r.Seconds})
+}
+
+func (r *SqlidRow) UnmarshalJSON(b []byte) error {
+	var j sqlidJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	*r = SqlidRow{j.Inst_id, nullStr(j.Sql_id), nullInt(j.Sql_child_number), j.Seconds}
+	return nil
+}
+
+type sessionJSON struct {
+	Inst_id int     `json:"inst_id,omitempty"`
+	Sid     *string `json:"sid"`
+	Serial  *string `json:"serial"`
+	Seconds int     `json:"seconds"`
+}
+
+func (r SessionRow) MarshalJSON() ([]byte, error) {
+	return json.Marshal(sessionJSON{r.Inst_id, strPtr(r.Sid), strPtr(r.Serial), r.Seconds})
+}
+
+func (r *SessionRow) UnmarshalJSON(b []byte) error {
+	var j sessionJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	*r = SessionRow{j.Inst_id, nullStr(j.Sid), nullStr(j.Serial), j.Seconds}
+	return nil
+}
+
+type eventJSON struct {
+	Event      *string `json:"event"`
+	Wait_class *string `json:"wait_class"`
+	Seconds    int     `json:"seconds"`
+}
+
+func (r EventRow) MarshalJSON() ([]byte, error) {
+	return json.Marshal(eventJSON{strPtr(r.Event), strPtr(r.Wait_class), r.Seconds})
+}
+
+func (r *EventRow) UnmarshalJSON(b []byte) error {
+	var j eventJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	*r = EventRow{nullStr(j.Event), nullStr(j.Wait_class), j.Seconds}
+	return nil
+}
+
+type sqltextJSON struct {
+	Inst_id         int    `json:"inst_id,omitempty"`
+	Sql_id          string `json:"sql_id"`
+	Child_number    *int64 `json:"child_number,omitempty"`
+	Plan            *int64 `json:"plan_hash_value"`
+	Sqltext         string `json:"sql_text"`
+	Parsing_User_Id *int64 `json:"parsing_user_id"`
+}
+
+func (r SqltextRow) MarshalJSON() ([]byte, error) {
+	return json.Marshal(sqltextJSON{r.Inst_id, r.Sql_id, intPtr(r.Child_number), intPtr(r.Plan), r.Sqltext, intPtr(r.Parsing_User_Id)})
+}
+
+func (r *SqltextRow) UnmarshalJSON(b []byte) error {
+	var j sqltextJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	*r = SqltextRow{j.Inst_id, j.Sql_id, nullInt(j.Child_number), nullInt(j.Plan), j.Sqltext, nullInt(j.Parsing_User_Id)}
+	return nil
+}
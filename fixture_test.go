@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFixtureSqls(t *testing.T) {
+	c, err := newFixtureCollector("fixtures/rac.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the cursors missing in gv$sql are skipped, the ones after them kept
+	want := []string{"1 5qgz1p0cut7mx 0,2 8pz8wx8xbq3t1 2", "2 8pz8wx8xbq3t1 2"}
+	for i, w := range want {
+		if _, err = c.Metrics(ashScope{}); err != nil {
+			t.Fatal(err)
+		}
+		sqls, err := c.Sqls(c.cur().Sqlids)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, r := range sqls {
+			got = append(got, fmt.Sprintf("%d %s %d", r.Inst_id, r.Sql_id, r.Child_number.Int64))
+		}
+		if strings.Join(got, ",") != w {
+			t.Errorf("frame %d: got %v, want %s", i, got, w)
+		}
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	fx, err := newFixtureCollector("fixtures/sample.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "oradash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rec, err := newRecorder(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := ashScope{minutes: 5, rows: 10, inst: -1}
+	var recorded []frame
+	var prev frame
+	for range fx.frames {
+		prev = refresh(fx, sc, prev, rec)
+		recorded = append(recorded, prev)
+	}
+	rec.Close()
+	files, _ := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if len(files) != 1 {
+		t.Fatalf("recorded %v", files)
+	}
+	rp, err := newReplayCollector(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the replay shows what was recorded, the failed panels included
+	prev = frame{}
+	for i, want := range recorded {
+		got := collect(rp, sc, prev)
+		if a, b := frameJSON(t, got), frameJSON(t, want); a != b {
+			t.Errorf("frame %d:\n got %s\nwant %s", i, a, b)
+		}
+		prev = got
+	}
+	if len(recorded[3].Errs) == 0 {
+		t.Error("the errors of the fixture weren't recorded")
+	}
+	if !rp.done() || rp.delay(1) != 24*time.Hour {
+		t.Error("the replay doesn't stop at the last frame")
+	}
+	collect(rp, sc, prev)
+	if rp.idx != len(recorded)-1 {
+		t.Errorf("the replay moved on to frame %d", rp.idx)
+	}
+}
+
+// frameJSON is fr without the time of the refresh
+func frameJSON(t *testing.T, fr frame) string {
+	fr.Time = time.Time{}
+	b, err := json.Marshal(fr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
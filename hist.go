@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// AWR keeps one ASH sample out of ten
+const histSampleSecs = 10
+
+// times on the command line and in the header, database time
+const histTimeFormat = "2006-01-02 15:04"
+
+// ashFrom is the ASH source for sc with the columns the top queries use:
+// gv$active_session_history for the last minutes, AWR for a range.
+// Further conditions are appended with "and".
+func ashFrom(sc ashScope) string {
+	const cols = "sample_time, session_id, session_serial#, session_state, sql_id, sql_child_number, sql_plan_line_id, event, wait_class"
+	if sc.live() {
+		return fmt.Sprintf(`(select inst_id, %s
+	 from gv$active_session_history where sample_time >= sysdate-%d/1440) ash
+	 where %s`, cols, sc.minutes, instFilter(sc))
+	}
+	return fmt.Sprintf(`(select instance_number inst_id, %s
+	 from dba_hist_active_sess_history
+	 where dbid = (select dbid from v$database) and sample_time >= %s and sample_time < %s) ash
+	 where %s`, cols, oraDate(sc.from), oraDate(sc.to), instFilter(sc))
+}
+
+// sampleSecs is how many seconds of DB time one ASH row of sc stands for
+func sampleSecs(sc ashScope) int {
+	if sc.live() {
+		return 1
+	}
+	return histSampleSecs
+}
+
+func oraDate(t time.Time) string {
+	return "to_date('" + t.Format("2006-01-02 15:04:05") + "', 'YYYY-MM-DD HH24:MI:SS')"
+}
+
+// histRange turns the -at or -from/-to flags into an AWR range; at is the end of a window
+func histRange(at, from, to string, window int) (time.Time, time.Time, error) {
+	var t1, t2 time.Time
+	var err error
+	switch {
+	case at != "" && (from != "" || to != ""):
+		return t1, t2, fmt.Errorf("-at can't be used with -from/-to")
+	case at != "":
+		if t2, err = time.ParseInLocation(histTimeFormat, at, time.Local); err != nil {
+			return t1, t2, err
+		}
+		t1 = t2.Add(-time.Duration(window) * time.Minute)
+	case from != "" || to != "":
+		if from == "" || to == "" {
+			return t1, t2, fmt.Errorf("-from and -to go together")
+		}
+		if t1, err = time.ParseInLocation(histTimeFormat, from, time.Local); err != nil {
+			return t1, t2, err
+		}
+		if t2, err = time.ParseInLocation(histTimeFormat, to, time.Local); err != nil {
+			return t1, t2, err
+		}
+		if !t1.Before(t2) {
+			return t1, t2, fmt.Errorf("-from must be before -to")
+		}
+	}
+	return t1, t2, nil
+}
+
+// scrub moves the AWR range of sc by its length, back or forth.
+// Moving forth beyond now goes back to the live ASH.
+func scrub(sc ashScope, back bool) ashScope {
+	d := time.Duration(sc.minutes) * time.Minute
+	if sc.live() {
+		if !back {
+			return sc
+		}
+		sc.to = time.Now().Truncate(time.Minute)
+		sc.from = sc.to.Add(-d)
+	}
+	if !back {
+		d = -d
+	}
+	sc.from, sc.to = sc.from.Add(-d), sc.to.Add(-d)
+	if sc.to.After(time.Now()) {
+		sc.from, sc.to = time.Time{}, time.Time{}
+	}
+	return sc
+}
+
+// histMetrics averages the DBA_HIST_SYSMETRIC_SUMMARY intervals overlapping the range of sc
+func histMetrics(db *sqlx.DB, sc ashScope) (instanceMetrics, error) {
+	var im instanceMetrics
+	im.iname, im.startup, im.inst = instanceName(db, sc)
+	im.cpus = cpuCount(db, sc)
+	im.mtime = sc.from.Format("01-02 15:04") + "-" + sc.to.Format("15:04")
+	rows, err := db.Query(`select metric_name,
+  decode(metric_name, 'Host CPU Utilization (%)', avg(value), 'Database CPU Time Ratio', avg(value), sum(value)) value
+from
+  (select instance_number inst_id, metric_name, avg(average) value
+   from dba_hist_sysmetric_summary
+   where dbid = (select dbid from v$database) and end_time > ` + oraDate(sc.from) + ` and begin_time < ` + oraDate(sc.to) + `
+     and metric_name in (` + sysmetricNames + `)
+   group by instance_number, metric_name)
+where ` + instFilter(sc) + `
+group by metric_name`)
+	if err != nil {
+		return im, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			nam string
+			val float32
+		)
+		if err = rows.Scan(&nam, &val); err != nil {
+			return im, err
+		}
+		im.set(nam, val)
+	}
+	return im, rows.Err()
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// metricHistory is a ring buffer with the instance metrics of the last refreshes
+type metricHistory struct {
+	buf  []instanceMetrics
+	next int // where the next one goes
+	n    int // how many are kept
+}
+
+// mhist feeds the sparklines and the charts
+var mhist = newMetricHistory(240)
+
+func newMetricHistory(size int) *metricHistory {
+	return &metricHistory{buf: make([]instanceMetrics, size)}
+}
+
+// add keeps the metrics of a live frame, unless reading them failed
+func (h *metricHistory) add(fr frame) {
+	if _, failed := fr.Errs["metrics"]; failed || fr.From != nil {
+		return
+	}
+	h.buf[h.next] = fr.Metrics
+	h.next = (h.next + 1) % len(h.buf)
+	if h.n < len(h.buf) {
+		h.n++
+	}
+}
+
+func (h *metricHistory) reset() {
+	h.next, h.n = 0, 0
+}
+
+// values returns up to max values of the metric key, oldest first
+func (h *metricHistory) values(key string, max int) []float32 {
+	n := h.n
+	if n > max {
+		n = max
+	}
+	res := make([]float32, n)
+	for i := range res {
+		im := h.buf[(h.next-n+i+len(h.buf))%len(h.buf)]
+		res[i] = im.value(key)
+	}
+	return res
+}
+
+// value returns a metric by its metricCells key, in the unit shown
+func (im instanceMetrics) value(key string) float32 {
+	switch key {
+	case "cpuutil":
+		return im.cpuutil
+	case "cpuratio":
+		return im.cpuratio
+	case "aas":
+		return im.aas
+	case "execs":
+		return im.execs
+	case "calls":
+		return im.calls
+	case "tnxs":
+		return im.tnxs
+	case "lios":
+		return im.lios
+	case "phyrd":
+		return im.phyrd
+	case "phywr":
+		return im.phywr
+	case "blkgets":
+		return im.blkgets
+	case "blkchng":
+		return im.blkchng
+	case "redomb":
+		return im.redomb / 1024 / 1024
+	case "fullindscan":
+		return im.fullindscan
+	case "totindscan":
+		return im.totindscan
+	case "tottabscan":
+		return im.tottabscan
+	}
+	return 0
+}
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+func maxOf(vals []float32) float32 {
+	var max float32
+	for _, v := range vals {
+		if v > max {
+			max = v
+		}
+	}
+	return max
+}
+
+// sparkline draws vals from 0 to their maximum, right aligned in w characters
+func sparkline(vals []float32, w int) string {
+	if len(vals) > w {
+		vals = vals[len(vals)-w:]
+	}
+	max := maxOf(vals)
+	var b strings.Builder
+	b.WriteString(strings.Repeat(" ", w-len(vals)))
+	for _, v := range vals {
+		i := 0
+		if max > 0 {
+			i = int(v / max * float32(len(sparkBlocks)-1))
+		}
+		b.WriteRune(sparkBlocks[i])
+	}
+	return b.String()
+}
+
+func printSparks(S map[string]F) {
+	fmt.Fprint(out, fg(25))
+	for _, m := range metricCells {
+		if f, ok := S[m.key+".spark"]; ok {
+			fmt.Fprint(out, xy(f.x, f.y), sparkline(mhist.values(m.key, f.w), f.w))
+		}
+	}
+	fmt.Fprint(out, fg(16))
+}
+
+// showChart draws the history of the metric metricCells[i] over the whole screen
+func showChart(i int, S map[string]F) {
+	if _, ok := S["metrics"]; !ok {
+		// the terminal is too small, printTemplate says so
+		printTemplate(S)
+		return
+	}
+	scr := S["screen"]
+	m := metricCells[i]
+	const axis = 11 // "%9.1f │"
+	vals := mhist.values(m.key, scr.w-axis-1)
+	h := scr.h - 5
+	max := maxOf(vals)
+	if max == 0 {
+		max = 1
+	}
+
+	fmt.Fprint(out, fg(16), bg(255), Cls, xy(1, 1))
+	fmt.Fprint(out, fg(17), BoldFont, strings.TrimSuffix(m.label, ":"), fmt.Sprintf(", last %d refreshes", len(vals)), fg(16))
+	for r := 0; r < h; r++ {
+		label := ""
+		switch r {
+		case 0:
+			label = fmt.Sprintf("%9.1f", max)
+		case h - 1:
+			label = fmt.Sprintf("%9.1f", 0.0)
+		}
+		fmt.Fprint(out, xy(1, 3+r), fmt.Sprintf("%9s │", label))
+	}
+	fmt.Fprint(out, xy(axis, 3+h), "└", strings.Repeat("─", scr.w-axis-1))
+	// every value is a column of full blocks topped with a partial one
+	fmt.Fprint(out, fg(25))
+	for c, v := range vals {
+		units := int(v / max * float32(h*8))
+		for r := 0; r < h && units > 0; r++ {
+			b := sparkBlocks[7]
+			if units < 8 {
+				b = sparkBlocks[units-1]
+			}
+			fmt.Fprint(out, xy(axis+1+c, 2+h-r), string(b))
+			units -= 8
+		}
+	}
+	fmt.Fprint(out, fg(17), xy(1, scr.h), "left/right: other metrics, any other key to return", fg(16))
+}
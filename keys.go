@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// csiKeys maps the tail of an escape sequence to a key name
+var csiKeys = map[string]string{
+	"A": "up", "B": "down", "C": "right", "D": "left",
+	"H": "home", "F": "end", "1~": "home", "4~": "end",
+	"5~": "pgup", "6~": "pgdn",
+}
+
+// readKeys decodes stdin into key names: "esc", "enter", "tab", the names
+// in csiKeys or the typed character itself. It waits for input before
+// reading so the keys typed into an external program are left alone.
+func readKeys(keys chan<- string) {
+	b := make([]byte, 32)
+	fds := []unix.PollFd{{Fd: int32(os.Stdin.Fd()), Events: unix.POLLIN}}
+	for {
+		if atomic.LoadInt32(&external) == 1 {
+			time.Sleep(50 * time.Millisecond)
+			continue
+		}
+		if n, err := unix.Poll(fds, 100); n == 0 || err == unix.EINTR || atomic.LoadInt32(&external) == 1 {
+			continue
+		}
+		n, err := os.Stdin.Read(b)
+		if err != nil {
+			close(keys)
+			return
+		}
+		for i := 0; i < n; i++ {
+			switch {
+			case b[i] == 0x1b && i+2 < n && (b[i+1] == '[' || b[i+1] == 'O'):
+				j := i + 2
+				for j < n-1 && (b[j] < 0x40 || b[j] > 0x7e) {
+					j++
+				}
+				if k, ok := csiKeys[string(b[i+2:j+1])]; ok {
+					keys <- k
+				}
+				i = j
+			case b[i] == 0x1b:
+				keys <- "esc"
+			case b[i] == '\r' || b[i] == '\n':
+				keys <- "enter"
+			case b[i] == '\t':
+				keys <- "tab"
+			default:
+				keys <- string(b[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// metricCell is one label/value pair of the INSTANCE METRICS box
+type metricCell struct {
+	key   string
+	label string
+	w     int // value width
+}
+
+var metricCells = []metricCell{
+	{"cpuutil", "CPU Util:", 4},
+	{"cpuratio", "DB CPU TmRatio:", 4},
+	{"aas", "AvgAct Sessions:", 5},
+	{"execs", "Execs/s:", 9},
+	{"calls", "Calls/s:", 9},
+	{"tnxs", "Tnxs/s:", 9},
+	{"lios", "LRDs/s:", 7},
+	{"phyrd", "PhyRD/s:", 7},
+	{"phywr", "PhyWR/s:", 7},
+	{"blkgets", "Blk Gets/s:", 7},
+	{"blkchng", "Blk Chng/s:", 7},
+	{"redomb", "Redo MB/s:", 7},
+	{"fullindscan", "FulIdxSc/s:", 7},
+	{"totindscan", "TotIdxSc/s:", 7},
+	{"tottabscan", "TotTblSc/s:", 7},
+}
+
+// boxCol is a titled column of a box, its content is S[key]
+type boxCol struct {
+	key   string
+	title string
+}
+
+// boxes are the panels a layout places under the metrics box.
+// A box is drawn if S["box."+name] is set, with the columns which are in S.
+var boxes = map[string][]boxCol{
+	"top":      {{"topsqlids", "TOP SQL_ID (child#)"}, {"sqlinst", "INST"}, {"topsids", "TOP SESSIONS"}, {"sidinst", "INST"}},
+	"sqlids":   {{"topsqlids", "TOP SQL_ID (child#)"}, {"sqlinst", "INST"}},
+	"sessions": {{"topsids", "TOP SESSIONS"}, {"sidinst", "INST"}},
+	"events":   {{"events", "TOP WAITS"}, {"waitclasses", "WAIT CLASS"}},
+	"activity": {{"activity", "ACTIVITY"}, {"legend", ""}},
+	"alerts":   {{"alerts", "ALERTS"}},
+	"sqltext":  {{"sqlid", "SQL_ID"}, {"phv", "PLAN_HV"}, {"sqltext", "SQL_TEXT"}},
+}
+
+// screenLayout is the rows of boxes under the metrics box, top to bottom.
+// It is read from a -layout file.
+type screenLayout struct {
+	Rows []layoutRow `json:"rows"`
+}
+
+type layoutRow struct {
+	Height int           `json:"height,omitempty"` // rows, 0 to share what is left with the other such rows
+	Pct    int           `json:"pct,omitempty"`    // or a percentage of the rows left
+	Min    int           `json:"min,omitempty"`    // fewest rows worth showing with pct
+	Panels []layoutPanel `json:"panels"`
+}
+
+type layoutPanel struct {
+	Panel string `json:"panel"`           // a name from boxes
+	Width int    `json:"width,omitempty"` // columns including the borders, 0 for the natural width or a share of the rest
+}
+
+var defaultLayout = screenLayout{[]layoutRow{
+	{Pct: 25, Min: 4, Panels: []layoutPanel{{Panel: "activity"}}},
+	{Panels: []layoutPanel{{Panel: "top"}, {Panel: "events"}}},
+	{Panels: []layoutPanel{{Panel: "sqltext"}}},
+	{Height: 4, Panels: []layoutPanel{{Panel: "alerts"}}},
+}}
+
+var screen = defaultLayout
+
+// loadLayout reads a layout file; every field may be in one panel only
+func loadLayout(fname string) (screenLayout, error) {
+	var sl screenLayout
+	b, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return sl, err
+	}
+	if err = json.Unmarshal(b, &sl); err != nil {
+		return sl, fmt.Errorf("%s: %v", fname, err)
+	}
+	owner := make(map[string]string)
+	for _, r := range sl.Rows {
+		for _, p := range r.Panels {
+			cols, ok := boxes[p.Panel]
+			if !ok {
+				return sl, fmt.Errorf("%s: unknown panel %q", fname, p.Panel)
+			}
+			if mw := minWidth(p.Panel, view{}); p.Width != 0 && p.Width < mw {
+				return sl, fmt.Errorf("%s: panel %q needs a width of %d at least", fname, p.Panel, mw)
+			}
+			for _, c := range cols {
+				if o, ok := owner[c.key]; ok {
+					return sl, fmt.Errorf("%s: panels %q and %q both show %s", fname, o, p.Panel, c.key)
+				}
+				owner[c.key] = p.Panel
+			}
+		}
+	}
+	return sl, nil
+}
+
+const minW, minH = 72, 14
+
+// narrowest sparkline worth drawing
+const sparkW = 8
+
+// view is what the layout depends on besides the screen size
+type view struct {
+	cluster  bool // INST columns next to the top SQL and sessions
+	sparks   bool // sparklines in the metrics box if they fit
+	activity bool // the activity chart if the panels keep 4 rows
+	alerts   bool // the alert history if the panels keep 4 rows
+}
+
+// layout computes every field for a w x h screen.
+// S["screen"] holds the screen size, S["metrics"] and S["mcolN"] the metrics box,
+// S[key+".spark"] the sparkline of a metric.
+func layout(w, h int, v view) map[string]F {
+	S := make(map[string]F)
+	S["screen"] = F{1, 1, w, h}
+	if w < minW || h < minH {
+		return S
+	}
+
+	// as many metric columns as fit, with sparklines if possible;
+	// the spare width is spread evenly and widens the sparklines
+	tries := [][2]int{{5, 0}, {3, 0}, {2, 0}} // columns, sparkline width
+	if v.sparks {
+		tries = append([][2]int{{5, sparkW}, {3, sparkW}}, tries...)
+	}
+	var cols [][]metricCell
+	var widths []int
+	spark := 0
+	for _, t := range tries {
+		ncols := t[0]
+		nrows := (len(metricCells) + ncols - 1) / ncols
+		cols, widths, spark = nil, nil, t[1]
+		total := 1
+		for c := 0; c < ncols; c++ {
+			end := (c + 1) * nrows
+			if end > len(metricCells) {
+				end = len(metricCells)
+			}
+			col := metricCells[c*nrows : end]
+			cw := 0
+			for _, m := range col {
+				if l := len(m.label) + 1 + m.w; l > cw {
+					cw = l
+				}
+			}
+			if spark > 0 {
+				lw, vw := labelValueW(col)
+				cw = lw + 1 + spark + 1 + vw
+			}
+			cols = append(cols, col)
+			widths = append(widths, cw)
+			total += cw + 3
+		}
+		if total <= w {
+			for i := range widths {
+				widths[i] += (w - total) / ncols
+			}
+			widths[len(widths)-1] += (w - total) % ncols
+			break
+		}
+	}
+	mrows := len(cols[0])
+	S["metrics"] = F{1, 1, w, mrows}
+	x := 1
+	for c, col := range cols {
+		S[fmt.Sprintf("mcol%d", c)] = F{x + 2, 2, widths[c], mrows}
+		// sparklines are aligned between the longest label and the widest value
+		lw, vw := labelValueW(col)
+		for r, m := range col {
+			S[m.key] = F{x + 2, 2 + r, widths[c], 1}
+			if spark > 0 {
+				S[m.key+".spark"] = F{x + 3 + lw, 2 + r, widths[c] - lw - vw - 2, 1}
+			}
+		}
+		x += widths[c] + 3
+	}
+
+	// the rows of the layout share what is left, one line is kept for status.
+	// Rows with a height or a percentage are left out when the rows
+	// sharing the rest would get less than 4 lines.
+	var rows []layoutRow
+	for _, r := range screen.Rows {
+		var ps []layoutPanel
+		for _, p := range r.Panels {
+			if p.Panel == "activity" && !v.activity || p.Panel == "alerts" && !v.alerts {
+				continue
+			}
+			ps = append(ps, p)
+		}
+		if len(ps) > 0 {
+			r.Panels = ps
+			rows = append(rows, r)
+		}
+	}
+	nflex := 0
+	for _, r := range rows {
+		if r.Height == 0 && r.Pct == 0 {
+			nflex++
+		}
+	}
+	avail := h - 1 - (mrows + 2) - 2*nflex
+	heights := make([]int, len(rows))
+	for i, r := range rows {
+		if r.Height == 0 && r.Pct == 0 {
+			continue
+		}
+		rh := r.Height
+		if r.Pct > 0 {
+			if rh = avail * r.Pct / 100; rh < r.Min {
+				rh = r.Min
+			}
+		}
+		if nflex > 0 && (avail-rh-2)/nflex < 4 || avail-rh-2 < 0 {
+			continue
+		}
+		heights[i] = rh
+		avail -= rh + 2
+	}
+	y := mrows + 4
+	for i, r := range rows {
+		n := heights[i]
+		if r.Height == 0 && r.Pct == 0 {
+			n = avail / nflex
+		}
+		if n <= 0 {
+			continue
+		}
+		placeRow(S, r.Panels, y, w, n, v)
+		y += n + 2
+	}
+	return S
+}
+
+// placeRow puts the boxes of a row side by side; the ones without a width
+// or a natural width share what is left. Boxes are left out from the right
+// until the others fit with their minimum width.
+func placeRow(S map[string]F, panels []layoutPanel, y, w, h int, v view) {
+	for n := len(panels); n > 0; n-- {
+		widths := rowWidths(panels[:n], w, v)
+		if widths == nil {
+			continue
+		}
+		x := 1
+		for i, p := range panels[:n] {
+			place(S, p.Panel, x, y, widths[i], h, v)
+			x += widths[i]
+		}
+		return
+	}
+}
+
+// rowWidths are the widths of boxes side by side in w columns, nil if they don't fit
+func rowWidths(panels []layoutPanel, w int, v view) []int {
+	widths := make([]int, len(panels))
+	rest, nflex := w, 0
+	for i, p := range panels {
+		if widths[i] = p.Width; widths[i] == 0 {
+			widths[i] = naturalWidth(p.Panel, v)
+		}
+		if widths[i] == 0 {
+			nflex++
+		}
+		rest -= widths[i]
+	}
+	for i, p := range panels {
+		if widths[i] == 0 {
+			widths[i] = rest / nflex
+			rest -= widths[i]
+			nflex--
+		}
+		if widths[i] < minWidth(p.Panel, v) {
+			return nil
+		}
+	}
+	if rest < 0 {
+		return nil
+	}
+	return widths
+}
+
+// naturalWidth is the width of a box of fixed columns, 0 for the stretching ones
+func naturalWidth(panel string, v view) int {
+	inst := 0
+	if v.cluster {
+		inst = 4 + 3
+	}
+	switch panel {
+	case "top":
+		return 24 + 3 + 18 + 3 + 1 + 2*inst
+	case "sqlids":
+		return 24 + 3 + 1 + inst
+	case "sessions":
+		return 18 + 3 + 1 + inst
+	}
+	w := 1
+	for _, c := range panels[panel].Columns {
+		if c.Width == 0 {
+			return 0
+		}
+		w += c.Width + 3
+	}
+	if w == 1 {
+		return 0
+	}
+	return w
+}
+
+// minWidth is the narrowest box of a panel which has room for all its columns
+func minWidth(panel string, v view) int {
+	if w := naturalWidth(panel, v); w > 0 {
+		return w
+	}
+	switch panel {
+	case "events":
+		return 20
+	case "alerts":
+		return 24
+	case "activity":
+		return 42
+	case "sqltext":
+		return 44
+	}
+	w := 1
+	for _, c := range panels[panel].Columns {
+		if c.Width > 0 {
+			w += c.Width + 3
+		} else {
+			w += 4
+		}
+	}
+	return w
+}
+
+// place sets the fields of a box at column x, y is its first line of content
+func place(S map[string]F, panel string, x, y, w, h int, v view) {
+	S["box."+panel] = F{x, y, w, h}
+	x += 2
+	switch panel {
+	case "top", "sqlids", "sessions":
+		if panel != "sessions" {
+			S["topsqlids"] = F{x, y, 24, h}
+			x += 27
+			if v.cluster {
+				S["sqlinst"] = F{x, y, 4, h}
+				x += 7
+			}
+		}
+		if panel != "sqlids" {
+			S["topsids"] = F{x, y, 18, h}
+			x += 21
+			if v.cluster {
+				S["sidinst"] = F{x, y, 4, h}
+			}
+		}
+	case "events":
+		if ew := w - 21; ew >= 20 {
+			S["events"] = F{x, y, ew, h}
+			S["waitclasses"] = F{x + ew + 3, y, 14, h}
+		} else {
+			S["events"] = F{x, y, w - 4, h}
+		}
+	case "activity":
+		S["activity"] = F{x, y, w - 22, h}
+		S["legend"] = F{x + w - 19, y, 15, h}
+	case "alerts":
+		S["alerts"] = F{x, y, w - 4, h}
+	case "sqltext":
+		S["sqlid"] = F{x, y, 13, h}
+		S["phv"] = F{x + 16, y, 11, h}
+		S["sqltext"] = F{x + 30, y, w - 34, h}
+	default:
+		placeColumns(S, panel, x, y, w-1, h)
+	}
+}
+
+// placeColumns lays out the columns of a custom panel in w columns from x;
+// the ones without a width share the rest, those which don't fit are left out
+func placeColumns(S map[string]F, panel string, x, y, w, h int) {
+	cols := panels[panel].Columns
+	rest, nflex := w, 0
+	for _, c := range cols {
+		rest -= c.Width + 3
+		if c.Width == 0 {
+			nflex++
+		}
+	}
+	for i, c := range cols {
+		cw := c.Width
+		if cw == 0 {
+			cw = rest / nflex
+			rest -= cw
+			nflex--
+		}
+		if cw < 1 || x+cw > S["box."+panel].x+w-1 {
+			break
+		}
+		S[fmt.Sprintf("%s.%d", panel, i)] = F{x, y, cw, h}
+		x += cw + 3
+	}
+}
+
+// topRows is how many rows the top-N queries fetch for the panels of S
+func topRows(S map[string]F) int {
+	n := 0
+	for _, k := range []string{"topsqlids", "topsids", "events"} {
+		if S[k].h > n {
+			n = S[k].h
+		}
+	}
+	return n
+}
+
+// labelValueW returns the longest label and the widest value of a metrics column
+func labelValueW(col []metricCell) (int, int) {
+	lw, vw := 0, 0
+	for _, m := range col {
+		if len(m.label) > lw {
+			lw = len(m.label)
+		}
+		if m.w > vw {
+			vw = m.w
+		}
+	}
+	return lw, vw
+}
+
+// drawBox draws a box around cols, all of them must be in S.
+// Untitled columns are not joined to the borders.
+func drawBox(S map[string]F, cols []boxCol) {
+	first := S[cols[0].key]
+	top, bottom := "┌", "└"
+	for i, c := range cols {
+		f := S[c.key]
+		title := ""
+		if c.title != "" {
+			title = " " + c.title + " "
+		}
+		dashes := f.w + 2 - len(title)
+		if dashes < 0 {
+			title, dashes = fit(title, f.w+2), 0
+		}
+		bottom += strings.Repeat("─", f.w+2)
+		top += fg(17) + title + fg(16) + strings.Repeat("─", dashes)
+		if i < len(cols)-1 {
+			if cols[i+1].title == "" {
+				top += "─"
+				bottom += "─"
+			} else {
+				top += "┬"
+				bottom += "┴"
+			}
+		}
+	}
+	fmt.Fprint(out, xy(first.x-2, first.y-1), top, "┐")
+	fmt.Fprint(out, xy(first.x-2, first.y+first.h), bottom, "┘")
+	for r := 0; r < first.h; r++ {
+		for _, c := range cols {
+			f := S[c.key]
+			fmt.Fprint(out, xy(f.x-2, f.y+r), "│")
+		}
+		last := S[cols[len(cols)-1].key]
+		fmt.Fprint(out, xy(last.x+last.w+1, last.y+r), "│")
+	}
+}
+
+// fit pads or cuts s to exactly w characters
+func fit(s string, w int) string {
+	if w <= 0 {
+		return ""
+	}
+	r := []rune(s)
+	if len(r) > w {
+		return string(r[:w])
+	}
+	return s + strings.Repeat(" ", w-len(r))
+}
+
+// fitdots is fit which shows that s was cut
+func fitdots(s string, w int) string {
+	if w <= 0 {
+		return ""
+	}
+	if len([]rune(s)) > w && w > 2 {
+		return fit(s, w-2) + ".."
+	}
+	return fit(s, w)
+}
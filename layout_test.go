@@ -0,0 +1,132 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// every field has to be on the screen and wide enough to print into
+func checkFields(t *testing.T, S map[string]F, w, h int) {
+	t.Helper()
+	for k, f := range S {
+		if k == "screen" {
+			continue
+		}
+		if f.w < 1 || f.h < 1 || f.x < 1 || f.y < 1 || f.x+f.w-1 > w || f.y+f.h-1 > h {
+			t.Errorf("%dx%d: %s is %+v", w, h, k, f)
+		}
+	}
+}
+
+func TestLayoutSizes(t *testing.T) {
+	defer func(l screenLayout) { screen = l }(screen)
+	layouts := map[string]screenLayout{
+		"default": defaultLayout,
+		"narrow": {[]layoutRow{
+			{Panels: []layoutPanel{{Panel: "sqlids"}, {Panel: "sessions"}, {Panel: "events"}, {Panel: "activity"}}},
+			{Height: 5, Panels: []layoutPanel{{Panel: "sqltext", Width: 44}, {Panel: "alerts"}}},
+		}},
+	}
+	for name, l := range layouts {
+		screen = l
+		for _, v := range []view{{}, {cluster: true, sparks: true, activity: true, alerts: true}} {
+			for w := minW; w <= 200; w += 7 {
+				for h := minH; h <= 60; h += 5 {
+					S := layout(w, h, v)
+					checkFields(t, S, w, h)
+					if _, ok := S["metrics"]; !ok {
+						t.Errorf("%s %dx%d: no metrics box", name, w, h)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestPlaceRow(t *testing.T) {
+	tests := []struct {
+		panels []layoutPanel
+		w      int
+		placed []string
+	}{
+		{[]layoutPanel{{Panel: "top"}, {Panel: "events"}}, 111, []string{"top", "events"}},
+		{[]layoutPanel{{Panel: "top"}, {Panel: "events"}}, 72, []string{"top", "events"}},
+		// events gets the 19 columns left, one less than it needs
+		{[]layoutPanel{{Panel: "top"}, {Panel: "events"}}, 68, []string{"top"}},
+		{[]layoutPanel{{Panel: "sqltext"}, {Panel: "activity"}}, 80, []string{"sqltext"}},
+		// the two share 87 columns, sqltext would get 43
+		{[]layoutPanel{{Panel: "sqltext"}, {Panel: "activity"}}, 87, []string{"sqltext"}},
+		{[]layoutPanel{{Panel: "sqltext"}, {Panel: "activity"}}, 88, []string{"sqltext", "activity"}},
+		{[]layoutPanel{{Panel: "activity", Width: 50}, {Panel: "alerts"}}, 73, []string{"activity"}},
+		{[]layoutPanel{{Panel: "activity", Width: 50}, {Panel: "alerts"}}, 74, []string{"activity", "alerts"}},
+	}
+	for _, tt := range tests {
+		S := make(map[string]F)
+		placeRow(S, tt.panels, 10, tt.w, 5, view{})
+		var placed []string
+		for _, p := range tt.panels {
+			if _, ok := S["box."+p.Panel]; ok {
+				placed = append(placed, p.Panel)
+			}
+		}
+		if strings.Join(placed, ",") != strings.Join(tt.placed, ",") {
+			t.Errorf("%v in %d columns: placed %v, want %v", tt.panels, tt.w, placed, tt.placed)
+		}
+		checkFields(t, S, tt.w, 20)
+	}
+}
+
+func TestLoadLayout(t *testing.T) {
+	tests := []struct {
+		json string
+		err  string
+	}{
+		{`{"rows":[{"panels":[{"panel":"top"},{"panel":"events"}]},{"pct":30,"panels":[{"panel":"sqltext"}]}]}`, ""},
+		{`{"rows":[{"panels":[{"panel":"sqltext","width":30}]}]}`, `panel "sqltext" needs a width of 44 at least`},
+		{`{"rows":[{"panels":[{"panel":"activity","width":20}]}]}`, `panel "activity" needs a width of 42 at least`},
+		{`{"rows":[{"panels":[{"panel":"top"},{"panel":"sessions"}]}]}`, `panels "top" and "sessions" both show topsids`},
+		{`{"rows":[{"panels":[{"panel":"nosuch"}]}]}`, `unknown panel "nosuch"`},
+		{`{"rows":[`, `unexpected end of JSON input`},
+	}
+	for _, tt := range tests {
+		f, err := ioutil.TempFile("", "layout")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(tt.json)
+		f.Close()
+		_, err = loadLayout(f.Name())
+		os.Remove(f.Name())
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: %v", tt.json, err)
+		case tt.err != "" && (err == nil || !strings.HasSuffix(err.Error(), tt.err)):
+			t.Errorf("%s: got %v, want %q", tt.json, err, tt.err)
+		}
+	}
+}
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		s    string
+		w    int
+		fit  string
+		dots string
+	}{
+		{"abcdef", 4, "abcd", "ab.."},
+		{"ab", 4, "ab  ", "ab  "},
+		{"äöü", 2, "äö", "äö"},
+		{"abc", 0, "", ""},
+		{"abc", -2, "", ""},
+	}
+	for _, tt := range tests {
+		if got := fit(tt.s, tt.w); got != tt.fit {
+			t.Errorf("fit(%q, %d) = %q, want %q", tt.s, tt.w, got, tt.fit)
+		}
+		if got := fitdots(tt.s, tt.w); got != tt.dots {
+			t.Errorf("fitdots(%q, %d) = %q, want %q", tt.s, tt.w, got, tt.dots)
+		}
+	}
+}
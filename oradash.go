@@ -4,11 +4,12 @@ import (
 	"database/sql"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"os/exec"
+	"os/signal"
 	"strings"
-	"text/template"
+	"syscall"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -34,8 +35,11 @@ type instanceSummary struct {
 }
 
 type instanceMetrics struct {
-	iname string
-	mtime string
+	iname   string
+	mtime   string
+	startup string // instance startup time
+	cpus    int    // NUM_CPUS from v$osstat
+	inst    int    // inst_id, 0 for several instances
 	//
 	cpuutil     float32 // Host CPU Utilization (%)
 	cpuratio    float32 // Database CPU Time Ratio
@@ -54,22 +58,6 @@ type instanceMetrics struct {
 	tottabscan  float32 // Total Table Scans Per Sec
 }
 
-type SessionRecord struct {
-	Ashtime          int            `db:"ASHTIME"`
-	Sid              int            `db:"SID"`
-	Serial           int            `db:"SERIAL#"`
-	Username         sql.NullString `db:"USERNAME"`
-	Machine          sql.NullString `db:"MACHINE"`
-	Program          sql.NullString `db:"PROGRAM"`
-	Sql_id           sql.NullString `db:"SQL_ID"`
-	Sql_child_number sql.NullInt64  `db:"SQL_CHILD_NUMBER"`
-	Blocking_session sql.NullString `db:"BLOCKING_SESSION"`
-	Event            sql.NullString `db:"EVENT"`
-	Wait_class       sql.NullString `db:"WAIT_CLASS"`
-	Wait_time        sql.NullString `db:"WAIT_TIME"`
-	Seconds_in_wait  sql.NullString `db:"SECONDS_IN_WAIT"`
-}
-
 type F struct {
 	x int
 	y int
@@ -78,6 +66,9 @@ type F struct {
 }
 
 var stat1, stat2 map[string]int64
+
+// the dashboard is drawn to out, a snapshot swaps in a canvas
+var out io.Writer = os.Stdout
 var Cls = "\x1b[2J"
 var BoldFont = "\x1b[1m"
 
@@ -86,63 +77,74 @@ func xy(x int, y int) string {
 }
 
 func fg(c int) string {
-	return fmt.Sprintf("\x1b[38;5;%dm", c)
+	return color(38, c)
 }
 
 func bg(c int) string {
-	return fmt.Sprintf("\x1b[48;5;%dm", c)
+	return color(48, c)
 }
 
 func puts(s string, x int, y int, f int, b int) {
-	fmt.Print(xy(x, y))
-	fmt.Print(fg(f), bg(b))
-	fmt.Print(s)
+	fmt.Fprint(out, xy(x, y))
+	fmt.Fprint(out, fg(f), bg(b))
+	fmt.Fprint(out, s)
 }
 
 //var borderLabelFg = c216(0xee, 0xbb, 0x44)
 //var sysStatementFg = c216(8, 8, 8)
-type ScreenParams struct {
-	Tfg string // title Fg
-	Dfg string // default Fg
-	H   string // header Fg
-}
-
-const screenTemplate = `┌ {{.Tfg}}INSTANCE METRICS{{.Dfg}} ───────────────────────────────────────────────────────────────────────────────────────────┐
-│ {{.H}}CPU Util:{{.Dfg}}              │ {{.H}}Execs/s:{{.Dfg}}          │ {{.H}}LRDs/s:{{.Dfg}}          │ {{.H}}Blk Gets/s:{{.Dfg}}          │ {{.H}}FulIdxSc/s:{{.Dfg}}          │
-│ {{.H}}DB CPU TmRatio:{{.Dfg}}        │ {{.H}}Calls/s:{{.Dfg}}          │ {{.H}}PhyRD/s:{{.Dfg}}         │ {{.H}}Blk Chng/s:{{.Dfg}}          │ {{.H}}TotIdxSc/s:{{.Dfg}}          │
-│ {{.H}}AvgAct Sessions:{{.Dfg}}       │ {{.H}}Tnxs/s:{{.Dfg}}           │ {{.H}}PhyWR/s:{{.Dfg}}         │ {{.H}}Redo MB/s:{{.Dfg}}           │ {{.H}}TotTblSc/s:{{.Dfg}}          │
-└─────────────────────────────────────────────────────────────────────────────────────────────────────────────┘
-┌ {{.Tfg}}TOP SQL_ID (child#){{.Dfg}} ────────┬ {{.Tfg}}TOP SESSIONS{{.Dfg}} ──────┐┌ {{.Tfg}}TOP WAITS{{.Dfg}} ──────────────────────────────┬ {{.Tfg}}WAIT CLASS{{.Dfg}} ───┐
-│                             │                    ││                                         │               │
-│                             │                    ││                                         │               │
-│                             │                    ││                                         │               │
-│                             │                    ││                                         │               │
-│                             │                    ││                                         │               │
-└─────────────────────────────┴────────────────────┘└─────────────────────────────────────────┴───────────────┘
-┌ {{.Tfg}}SQL_ID{{.Dfg}} ───────┬ {{.Tfg}}PLAN_HV{{.Dfg}} ────┬ {{.Tfg}}SQL_TEXT{{.Dfg}} ─────────────────────────────────────────────────────────────────────┐
-│               │             │                                                                               │
-│               │             │                                                                               │
-│               │             │                                                                               │
-│               │             │                                                                               │
-│               │             │                                                                               │
-└───────────────┴─────────────┴───────────────────────────────────────────────────────────────────────────────┘`
 
 func printTemplate(S map[string]F) {
 	// tfg 214-yellow 34-darkgreen 22-darkestgreen
-	sp := ScreenParams{Tfg: "\x1b[38;5;17m", Dfg: "\x1b[38;5;16m", H: "\x1b[38;5;17m"}
-	t := template.Must(template.New("screenTemplate").Parse(screenTemplate))
-	fmt.Print(BoldFont, fg(16), bg(255), Cls, xy(1, 1)) // c216(0xff, 0xff, 0xaf)), bg(234))
-	err := t.Execute(os.Stdout, sp)
-	if err != nil {
-		panic("executing template:" + err.Error())
+	scr := S["screen"]
+	fmt.Fprint(out, BoldFont, fg(16), bg(255), Cls, xy(1, 1)) // c216(0xff, 0xff, 0xaf)), bg(234))
+	if _, ok := S["metrics"]; !ok {
+		fmt.Fprint(out, fmt.Sprintf("terminal is too small: %dx%d, need %dx%d", scr.w, scr.h, minW, minH))
+		return
+	}
+
+	var mcols []boxCol
+	for c := 0; ; c++ {
+		key := fmt.Sprintf("mcol%d", c)
+		if _, ok := S[key]; !ok {
+			break
+		}
+		mcols = append(mcols, boxCol{key, ""})
 	}
-	fmt.Print(fg(16), bg(255))
-	fmt.Print(xy(1, 23))
+	mcols[0].title = "INSTANCE METRICS"
+	drawBox(S, mcols)
+	fmt.Fprint(out, fg(17))
+	for _, m := range metricCells {
+		f := S[m.key]
+		fmt.Fprint(out, xy(f.x, f.y), m.label)
+	}
+	fmt.Fprint(out, fg(16))
+
+	for name, b := range boxes {
+		if _, ok := S["box."+name]; !ok {
+			continue
+		}
+		var cols []boxCol
+		for _, c := range b {
+			if _, ok := S[c.key]; ok {
+				cols = append(cols, c)
+			}
+		}
+		if len(cols) > 0 {
+			drawBox(S, cols)
+		}
+	}
+	fmt.Fprint(out, xy(1, scr.h))
 }
 
+// printF right-aligns v in the field fn, in the alert color if a rule fires on it
 func printF(S map[string]F, fn string, v string) {
 	if f, ok := S[fn]; ok {
-		fmt.Print(xy(f.x+f.w-len(v), f.y), v)
+		fmt.Fprint(out, xy(f.x+f.w-len(v), f.y))
+		if c, ok := alarms.cells[fn]; ok {
+			fmt.Fprint(out, fg(c), mark(v), fg(16))
+		} else {
+			fmt.Fprint(out, v)
+		}
 	}
 }
 
@@ -156,159 +158,622 @@ func curset(i byte) error {
 */
 
 func main() {
-	S := make(map[string]F)
-	S["cpuutil"] = F{10, 2, 15, 1}
-	S["cpuratio"] = F{10, 3, 15, 1}
-	S["aas"] = F{20, 4, 5, 1}
-	S["execs"] = F{36, 2, 9, 1}
-	S["calls"] = F{36, 3, 9, 1}
-	S["tnxs"] = F{36, 4, 9, 1}
-	S["lios"] = F{56, 2, 8, 1}
-	S["phyrd"] = F{57, 3, 7, 1}
-	S["phywr"] = F{57, 4, 7, 1}
-	S["blkgets"] = F{77, 2, 10, 1}
-	S["blkchng"] = F{77, 3, 10, 1}
-	S["redomb"] = F{76, 4, 11, 1}
-	S["fullindscan"] = F{100, 2, 10, 1}
-	S["totindscan"] = F{100, 3, 10, 1}
-	S["tottabscan"] = F{100, 4, 10, 1}
-	S["topsqlids"] = F{4, 7, 24, 5}
-	S["topsids"] = F{33, 7, 18, 5}
-	S["events"] = F{54, 7, 38, 5}
-	S["waitclasses"] = F{97, 7, 14, 5}
-	S["sqlid"] = F{3, 14, 13, 5}
-	S["phv"] = F{19, 14, 11, 5}
-	S["sqltext"] = F{33, 14, 78, 5}
-
+	// oradash snapshot [flags] <connect_string>
+	snap := len(os.Args) > 1 && os.Args[1] == "snapshot"
+	if snap {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+	fixture := flag.String("fixture", "", "read data from a fixture `file` instead of a database")
+	record := flag.String("record", "", "record every refresh to a timestamped file in `dir`")
+	replay := flag.String("replay", "", "replay a recorded `file`")
+	speed := flag.Float64("speed", 1, "replay speed multiplier")
+	interval := flag.Duration("interval", 10*time.Second, "refresh `period`")
+	window := flag.Int("window", 5, "ASH window in `minutes`")
+	cluster := flag.Bool("cluster", false, "start with all instances of a RAC cluster aggregated")
+	at := flag.String("at", "", "show AWR for the window ending at `time` (YYYY-MM-DD HH24:MI, database time)")
+	from := flag.String("from", "", "show AWR from `time` (YYYY-MM-DD HH24:MI), use with -to")
+	to := flag.String("to", "", "show AWR until `time` (YYYY-MM-DD HH24:MI)")
+	logfile := flag.String("log", "oradash.log", "append errors to `file`")
+	listen := flag.String("listen", "", "serve Prometheus metrics on `addr` instead of the dashboard")
+	top := flag.Int("top", 10, "number of top-N rows for -listen and -output")
+	output := flag.String("output", "", "write every refresh as json or csv instead of the dashboard")
+	outfile := flag.String("o", "", "write -output to `file` instead of stdout")
+	count := flag.Int("count", 0, "stop -output after `n` refreshes, 0 for no limit")
+	rules := flag.String("rules", "", "alert on the thresholds in `file`")
+	readOnly := flag.Bool("read-only", false, "disable the KILL and DISCONNECT SESSION keys")
+	audit := flag.String("audit", "oradash-audit.log", "log every KILL and DISCONNECT SESSION to `file`")
+	themeName := flag.String("theme", "light", "color `theme`: "+themeNames())
+	colors := flag.String("colors", "auto", "color `depth`: auto, truecolor, 256, 16 or none (also NO_COLOR)")
+	layoutfile := flag.String("layout", "", "arrange the panels as the JSON `file` says")
+	noASH := flag.Bool("no-ash", false, "sample gv$session every second instead of reading ASH, which needs the Diagnostics Pack")
+	cfgfile := flag.String("config", "", "read the settings from a JSON `file`, flags win over it")
 	flag.Parse()
+	// the exit code past the defers, os.Exit would skip them
+	code := 0
+	defer func() {
+		if code != 0 {
+			os.Exit(code)
+		}
+	}()
 
-	/*
-		lf, err := os.OpenFile("oradash.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
-			panic(err)
+	lf, err := os.OpenFile(*logfile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer lf.Close()
+	log.SetOutput(lf)
+	log.Printf("starting oradash")
+
+	var cfg config
+	if *cfgfile != "" {
+		if cfg, err = loadConfig(*cfgfile, *interval); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	set := flagSet()
+	if set["theme"] || cfg.Theme == "" {
+		cfg.Theme = *themeName
+	}
+	if set["colors"] || cfg.Colors == "" {
+		cfg.Colors = *colors
+	}
+	// NO_COLOR wins over the config file, only -colors wins over it
+	if !set["colors"] && os.Getenv("NO_COLOR") != "" {
+		cfg.Colors = "none"
+	}
+	if err = setTheme(cfg.Theme, cfg.Colors); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if set["layout"] || cfg.Layout == "" {
+		cfg.Layout = *layoutfile
+	}
+	if err = addPanels(cfg.Panels); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if cfg.Layout != "" {
+		if screen, err = loadLayout(cfg.Layout); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
-		defer lf.Close()
-		logger := log.New(lf, "", log.Ltime)
-		logger.Printf("starting oradash\n")
-	*/
+	} else {
+		screen = withPanels(defaultLayout)
+	}
 
-	if len(os.Args) < 2 {
-		fmt.Println("Usage:\n$ " + os.Args[0] + " <connect_string>")
+	if *window < 1 {
+		fmt.Println("-window must be at least 1 minute")
 		os.Exit(1)
 	}
-	var constring string
-	constring = os.Args[1]
-	db, err := sqlx.Open("goracle", constring)
+	if *interval <= 0 {
+		fmt.Println("-interval must be positive")
+		os.Exit(1)
+	}
+	if *speed <= 0 {
+		fmt.Println("-speed must be positive")
+		os.Exit(1)
+	}
+	sc := ashScope{minutes: *window, inst: -1}
+	sc.from, sc.to, err = histRange(*at, *from, *to, *window)
 	if err != nil {
-		panic(err)
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if *from != "" {
+		sc.minutes = int(sc.to.Sub(sc.from).Minutes())
+	}
+	if *cluster {
+		sc.inst = 0
+	}
+	if *noASH && !sc.live() {
+		fmt.Println("-no-ash can't show AWR, it needs the Diagnostics Pack too")
+		os.Exit(1)
+	}
+	if *noASH && snap {
+		// the sampler has taken one sample by the time the snapshot runs
+		fmt.Println("snapshot can't use -no-ash, there are no samples of the window yet")
+		os.Exit(1)
+	}
+	if *rules != "" {
+		if alarms.rules, err = loadRules(*rules); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 	}
-	defer db.Close()
-
-	exec.Command("stty", "-F", "/dev/tty", "cbreak", "min", "1").Run()
-	// do not display entered characters on the screen
-	exec.Command("stty", "-F", "/dev/tty", "-echo").Run()
-	// restore the echoing state when exiting
-	defer func() {
-		exec.Command("stty", "-F", "/dev/tty", "-cbreak").Run()
-		exec.Command("stty", "-F", "/dev/tty", "echo").Run()
-		fmt.Print("\x1b[?25h") // show cursor
-	}()
 
-	// first run
-	im, err := getMetrics(db)
+	var c Collector
+	var rp *fixtureCollector
+	if *replay != "" {
+		rp, err = newReplayCollector(*replay)
+		c = rp
+	} else if *fixture != "" {
+		c, err = newFixtureCollector(*fixture)
+	} else {
+		if flag.NArg() < 1 {
+			fmt.Println("Usage:\n$ " + os.Args[0] + " [-record <dir>] [-read-only] [-no-ash] [-at <time> | -from <time> -to <time>] <connect_string>\n$ " + os.Args[0] + " -fixture <file>\n$ " + os.Args[0] + " -replay <file> [-speed <x>]\n$ " + os.Args[0] + " -listen <addr> <connect_string>\n$ " + os.Args[0] + " snapshot <connect_string>")
+			os.Exit(1)
+		}
+		var oc *oracleCollector
+		if oc, err = newOracleCollector(flag.Arg(0)); err == nil {
+			c = oc
+			if *noASH {
+				// keep the largest window of the +/- keys too
+				keep := ashWindows[len(ashWindows)-1]
+				if *window > keep {
+					keep = *window
+				}
+				c, err = newSampler(oc, keep)
+			}
+		}
+	}
 	if err != nil {
-		panic(err)
+		fmt.Println(err)
+		os.Exit(1)
 	}
+	defer c.Close()
 
-	printTemplate(S)
-	printMetrics(im, S)
+	if snap {
+		code = snapshot(c, sc)
+		return
+	}
 
-	sqlidrows, err := ashTopSqlids(db)
-	if err != nil {
-		panic(err)
+	// without a screen the top-N is fixed
+	sc.rows = *top
+	if *listen != "" {
+		err = serveMetrics(c, sc, *listen, *interval, *top)
+		fmt.Println(err)
+		code = 1
+		return
 	}
-	printTopSqlids(sqlidrows, S)
 
-	sids, err := ashTopSids(db)
-	if err != nil {
-		panic(err)
+	wait := func() time.Duration {
+		if rp != nil {
+			return rp.delay(*speed)
+		}
+		if !c.Scope(sc).live() {
+			// AWR doesn't change, refresh on keys only
+			return 24 * time.Hour
+		}
+		return *interval
 	}
-	printTopSids(sids, S)
 
-	events, err := ashTopEvents(db)
-	if err != nil {
-		panic(err)
+	if *output != "" {
+		var w io.Writer = os.Stdout
+		header := true
+		if *outfile != "" {
+			f, err := os.OpenFile(*outfile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+			if err != nil {
+				fmt.Println(err)
+				code = 1
+				return
+			}
+			defer f.Close()
+			w = f
+			// appending to a CSV file which has its header already
+			if fi, err := f.Stat(); err == nil && fi.Size() > 0 {
+				header = false
+			}
+		}
+		fw, err := newFrameWriter(*output, w, header)
+		if err == nil {
+			err = stream(c, rp, sc, fw, wait, *count)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
+			return
+		}
+		return
 	}
-	printTopEvents(events, S)
 
-	sqls, err := getSqls(db, sqlidrows)
-	if err != nil {
-		panic(err)
+	var rec *recorder
+	if *record != "" {
+		rec, err = newRecorder(*record)
+		if err != nil {
+			fmt.Println(err)
+			code = 1
+			return
+		}
+		defer rec.Close()
 	}
-	printSqls(sqls, S)
 
-	var b []byte = make([]byte, 1)
+	if err = rawMode(); err != nil {
+		fmt.Println(err)
+		code = 1
+		return
+	}
+	// restore the terminal when exiting, including panics in main
+	defer restoreTerm()
+	redraw := make(chan struct{}, 1)
+	handleSignals(redraw)
+
+	// aggregated frames get the cluster layout
+	v := view{cluster: sc.inst == 0, sparks: true, activity: true, alerts: len(alarms.rules) > 0}
+	newPanels := false // custom panels came into view, their rows are fetched with the next resize
+	relayout := func() map[string]F {
+		w, h := termSize()
+		S := layout(w, h, v)
+		if showPanels(S) {
+			newPanels = true
+		}
+		return S
+	}
+	S := relayout()
+	sc.rows = topRows(S)
 
-	quit := make(chan struct{})
-	go func() {
-		for {
-			os.Stdin.Read(b)
-			if b[0] == 0x1b {
-				close(quit)
-				return
+	// first run
+	printTemplate(S)
+	fr := refresh(c, sc, frame{}, rec)
+	mhist.add(fr)
+	alarms.check(fr)
+	if fr.Cluster != v.cluster {
+		v.cluster = fr.Cluster
+		S = relayout()
+		printTemplate(S)
+	}
+	var sel selection
+	var cs connState
+	if rp == nil {
+		cs.update(fr)
+	}
+	printFrame(fr, sel, S)
+	printBanner(&cs, S)
+	next := time.Now().Add(wait())
+	if cs.down() {
+		next = time.Now().Add(time.Second)
+	}
+	detail := false
+	chart := -1      // metricCells index of the chart shown
+	blk := false     // the detail screen is the blocking tree, redrawn on refresh
+	var redo func()  // draws the open detail screen again after a resize
+	var tv *textView // the SQL text viewer, it takes the keys while open
+	var bv *textView // the blocking tree
+	bsel := 0        // the root blocker selected in the blocking tree
+	var pending *sessAction
+	_, fake := c.(*fixtureCollector)
+
+	// ask puts a kill or disconnect up for confirmation
+	ask := func(a sessAction, ok bool) {
+		switch {
+		case !ok:
+		case *readOnly:
+			cs.note = errReadOnly.Error()
+			printBanner(&cs, S)
+		case fake:
+			cs.note = errNoSessions.Error()
+			printBanner(&cs, S)
+		default:
+			pending = &a
+			printPrompt(a.prompt(), S)
+		}
+	}
+
+	// resize lays out the screen again and fetches what fits if the top panels changed
+	resize := func(S map[string]F, fr frame) (map[string]F, frame) {
+		S = relayout()
+		if rows := topRows(S); rows != sc.rows || newPanels {
+			sc.rows, newPanels = rows, false
+			if rp == nil {
+				fr = refresh(c, sc, fr, nil)
+				sel.clamp(fr)
 			}
-			//fmt.Println("I got the byte", b, "("+string(b)+")")
 		}
-	}()
+		return S, fr
+	}
+
+	keys := make(chan string)
+	safe(func() { readKeys(keys) })
 
 	//var cnt = 0
 
-	fmt.Print(xy(0, 23))
-	fmt.Print("\x1b[?25l") // turn off cursor
+	fmt.Fprint(out, xy(1, S["screen"].h))
+	fmt.Fprint(out, "\x1b[?25l") // turn off cursor
+
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	flash := time.NewTicker(500 * time.Millisecond)
+	defer flash.Stop()
 
 loop:
 	for {
 		select {
-		case <-quit:
-			break loop
-		case <-time.After(time.Millisecond * 10000):
-			im, err := getMetrics(db)
-			if err != nil {
-				panic(err)
+		case k, ok := <-keys:
+			if !ok {
+				break loop
 			}
-			printMetrics(im, S)
-
-			sqlidrows, err := ashTopSqlids(db)
-			if err != nil {
-				panic(err)
+			if pending != nil {
+				// anything but y cancels
+				msg := "cancelled"
+				if k == "y" {
+					msg = pending.run(c, *audit)
+				}
+				pending = nil
+				if blk {
+					redo()
+				}
+				cs.note = msg
+				printBanner(&cs, S)
+				fmt.Fprint(out, xy(1, S["screen"].h))
+				continue
 			}
-			printTopSqlids(sqlidrows, S)
-
-			sids, err := ashTopSids(db)
-			if err != nil {
-				panic(err)
+			if chart >= 0 {
+				switch k {
+				case "left", "h":
+					chart = (chart + len(metricCells) - 1) % len(metricCells)
+					showChart(chart, S)
+				case "right", "l":
+					chart = (chart + 1) % len(metricCells)
+					showChart(chart, S)
+				default:
+					// any other key closes the chart
+					chart = -1
+					printTemplate(S)
+					printFrame(fr, sel, S)
+				}
+				fmt.Fprint(out, xy(1, S["screen"].h))
+				continue
 			}
-			printTopSids(sids, S)
-
-			events, err := ashTopEvents(db)
-			if err != nil {
-				panic(err)
+			if tv != nil {
+				if tv.key(k, S) {
+					fmt.Fprint(out, xy(1, S["screen"].h))
+					continue
+				}
+				tv = nil
 			}
-			printTopEvents(events, S)
-
-			sqls, err := getSqls(db, sqlidrows)
-			if err != nil {
-				panic(err)
+			if blk {
+				switch k {
+				case "up", "k", "down", "j":
+					if k == "up" || k == "k" {
+						bsel--
+					} else {
+						bsel++
+					}
+					redo()
+					fmt.Fprint(out, xy(1, S["screen"].h))
+					continue
+				case "K", "D":
+					ask(blockerAction(fr.Blockers, bsel, k == "D"))
+					fmt.Fprint(out, xy(1, S["screen"].h))
+					continue
+				}
+				if bv.key(k, S) {
+					fmt.Fprint(out, xy(1, S["screen"].h))
+					continue
+				}
+			}
+			if detail {
+				// any key closes the detail screen
+				detail, blk = false, false
+				printTemplate(S)
+				printFrame(fr, sel, S)
+				fmt.Fprint(out, xy(1, S["screen"].h))
+				continue
+			}
+			switch k {
+			case "esc":
+				break loop
+			case "+", "-":
+				// change the ASH window and show it right away;
+				// a replay always shows what was recorded
+				if rp != nil {
+					break
+				}
+				sc.minutes = nextWindow(sc.minutes, k == "+")
+				if !sc.live() {
+					sc.from = sc.to.Add(-time.Duration(sc.minutes) * time.Minute)
+				}
+				fr = refresh(c, sc, fr, nil)
+				sel.clamp(fr)
+				printFrame(fr, sel, S)
+			case "left", "h", "right", "l":
+				// scrub through AWR, one window at a time;
+				// -no-ash has no license for AWR either
+				if rp != nil || *noASH {
+					break
+				}
+				sc = scrub(sc, k == "left" || k == "h")
+				fr = refresh(c, sc, fr, nil)
+				next = time.Now().Add(wait())
+				sel.clamp(fr)
+				printFrame(fr, sel, S)
+			case "i":
+				// next instance of a RAC cluster, after the last one all of them
+				if rp != nil {
+					break
+				}
+				ids, cur, err := c.Instances()
+				if err != nil {
+					logerr("instances: " + err.Error())
+					break
+				}
+				if len(ids) == 0 {
+					break
+				}
+				sc.inst = nextInst(sc.inst, cur, ids)
+				// another instance has another startup time, that's no restart
+				cs.startup = ""
+				mhist.reset()
+				fr = refresh(c, sc, frame{}, nil)
+				mhist.add(fr)
+				cs.update(fr)
+				sel.clamp(fr)
+				v.cluster = fr.Cluster
+				S = relayout()
+				printTemplate(S)
+				printFrame(fr, sel, S)
+			case "s":
+				// sparklines on and off, they take room from the panels
+				v.sparks = !v.sparks
+				S, fr = resize(S, fr)
+				printTemplate(S)
+				printFrame(fr, sel, S)
+			case "a":
+				v.activity = !v.activity
+				S, fr = resize(S, fr)
+				printTemplate(S)
+				printFrame(fr, sel, S)
+			case "b":
+				bsel = 0
+				bv = &textView{title: "BLOCKING SESSIONS", help: "up/down select a root blocker, K kills it, D disconnects it, q returns"}
+				redo = func() {
+					// the roots change with every refresh, the selected one stays in view
+					bsel = clampRoot(fr.Blockers, bsel)
+					lines, at := blockerLines(fr.Blockers, bsel)
+					bv.setLines(lines)
+					bv.see(at, S)
+					bv.draw(S)
+				}
+				redo()
+				detail, blk = true, true
+			case "x":
+				redo = func() { showDetail("ALERTS", alarms.lines(), S) }
+				redo()
+				detail = true
+			case "g":
+				chart = 0
+				showChart(chart, S)
+			case "tab":
+				sel = selection{panel: (sel.panel + 1) % 3}
+				printFrame(fr, sel, S)
+			case "up", "k":
+				sel.row--
+				sel.clamp(fr)
+				printFrame(fr, sel, S)
+			case "down", "j":
+				sel.row++
+				sel.clamp(fr)
+				printFrame(fr, sel, S)
+			case "enter":
+				if tv = openDetail(c, sc, fr, sel); tv != nil {
+					redo = func() { tv.draw(S) }
+					redo()
+					detail = true
+				}
+			case "K", "D":
+				if sel.panel == panelSids && sel.row < len(fr.Sids) {
+					r := fr.Sids[sel.row]
+					ask(sessAction{disconnect: k == "D", inst: r.Inst_id, sid: r.Sid.String, serial: r.Serial.String}, r.Sid.Valid)
+				}
+			case "p":
+				if tv = openPlan(c, sc, fr, sel); tv != nil {
+					redo = func() { tv.draw(S) }
+					redo()
+					detail = true
+				}
+			case "t":
+				if tv = openSqlText(c, fr, sel); tv != nil {
+					redo = func() { tv.draw(S) }
+					redo()
+					detail = true
+				}
+			}
+			fmt.Fprint(out, xy(1, S["screen"].h))
+		case <-flash.C:
+			// blink the title while rules fire
+			if alarms.firing() == 0 && !alarms.flash || detail || chart >= 0 {
+				break
+			}
+			alarms.flash = !alarms.flash && alarms.firing() > 0
+			if _, ok := S["metrics"]; ok {
+				printMetrics(fr.Metrics, S)
+			}
+			fmt.Fprint(out, xy(1, S["screen"].h))
+		case <-redraw:
+			// back from ^Z, the size may have changed meanwhile
+			S = relayout()
+			if chart >= 0 {
+				showChart(chart, S)
+			} else if detail {
+				redo()
+			} else {
+				printTemplate(S)
+				printFrame(fr, sel, S)
+			}
+			if pending != nil {
+				printPrompt(pending.prompt(), S)
+			}
+			fmt.Fprint(out, xy(1, S["screen"].h))
+		case <-winch:
+			S, fr = resize(S, fr)
+			if chart >= 0 {
+				showChart(chart, S)
+			} else if detail {
+				redo()
+			} else {
+				printTemplate(S)
+				printFrame(fr, sel, S)
+			}
+			if pending != nil {
+				printPrompt(pending.prompt(), S)
+			}
+			fmt.Fprint(out, xy(1, S["screen"].h))
+		case <-time.After(time.Until(next)):
+			if cs.down() {
+				// while disconnected only the banner ticks until the next attempt
+				next = time.Now().Add(time.Second)
+				if time.Now().Before(cs.retry) {
+					if !detail {
+						printBanner(&cs, S)
+					}
+					continue
+				}
+				if err := c.Reconnect(); err != nil {
+					logerr("reconnect: " + err.Error())
+					cs.failed()
+					if !detail {
+						printBanner(&cs, S)
+					}
+					continue
+				}
+			}
+			wasDown := cs.down()
+			fr = refresh(c, sc, fr, rec)
+			mhist.add(fr)
+			alarms.check(fr)
+			next = time.Now().Add(wait())
+			if rp == nil {
+				if cs.update(fr) {
+					resetDeltas(fr)
+				}
+				if cs.down() {
+					next = time.Now().Add(time.Second)
+				}
+			}
+			sel.clamp(fr)
+			if chart >= 0 {
+				showChart(chart, S)
+				continue
+			}
+			if blk {
+				redo()
+			}
+			if detail {
+				if pending != nil {
+					printPrompt(pending.prompt(), S)
+				}
+				continue
+			}
+			if fr.Cluster != v.cluster {
+				v.cluster = fr.Cluster
+				S = relayout()
+				printTemplate(S)
+			} else if wasDown && !cs.down() {
+				// get rid of the banner
+				printTemplate(S)
+			}
+			printFrame(fr, sel, S)
+			printBanner(&cs, S)
+			if pending != nil {
+				printPrompt(pending.prompt(), S)
 			}
-			printSqls(sqls, S)
 
-			fmt.Print(xy(0, 23))
-			fmt.Print("\x1b[?25l") // turn off cursor
+			fmt.Fprint(out, xy(1, S["screen"].h))
+			fmt.Fprint(out, "\x1b[?25l") // turn off cursor
 		}
 	}
 
-	//ashpoll(db)
-
 	//is := getInstanceSummary(db)
 
 	//sids := ashTopSids(db)
@@ -317,65 +782,261 @@ loop:
 
 }
 
-func printTopSqlids(sqlidrows []SqlidRow, S map[string]F) {
-	sF, _ := S["topsqlids"]
-	for i, sqlid := range sqlidrows {
-		val := fmt.Sprintf("%3d%% | %18s", sqlid.Seconds*100/300, fmt.Sprintf("%s (%d)", sqlid.Sql_id.String, sqlid.Sql_child_number.Int64))
-		fmt.Print(xy(sF.x+sF.w-len(val), sF.y+i), val)
+// refresh collects one round of data and records it if asked.
+// Errors are logged and kept in the frame, the panels that failed keep the data from prev.
+func refresh(c Collector, sc ashScope, prev frame, rec *recorder) frame {
+	fr := collect(c, sc, prev)
+	if rec != nil {
+		if err := rec.write(fr); err != nil {
+			fr.Errs["record"] = err.Error()
+		}
 	}
-	for i := len(sqlidrows); i < 5; i++ {
-		fmt.Print(xy(sF.x, sF.y+i), "                        ")
+	for _, p := range errPanels {
+		if e, ok := fr.Errs[p]; ok {
+			logerr(p + ": " + e)
+		}
 	}
+	return fr
 }
 
-func printTopSids(sids []SessionRow, S map[string]F) {
-	sF, _ := S["topsids"]
-	for i, sid := range sids {
-		val := fmt.Sprintf("%3d%% | %11s", sid.Seconds*100/300, fmt.Sprintf("%s,%s", sid.Sid.String, sid.Serial.String))
-		fmt.Print(xy(sF.x+sF.w-len(val), sF.y+i), val)
+// errPanels lists the keys of frame.Errs in the order they are reported
+var errPanels = []string{"metrics", "topsqlids", "topsids", "events", "sqltext", "activity", "blockers", "record"}
+
+func collect(c Collector, sc ashScope, prev frame) frame {
+	var err error
+	fr := frame{Time: time.Now(), Errs: make(map[string]string)}
+	if fr.Metrics, err = c.Metrics(sc); err != nil {
+		fr.Errs["metrics"] = err.Error()
+		fr.Metrics = prev.Metrics
+	}
+	s := c.Scope(sc)
+	fr.Window, fr.Secs, fr.Cluster = s.minutes, s.secs, s.inst == 0
+	if !s.live() {
+		fr.From, fr.To = &s.from, &s.to
+	}
+	if fr.Sqlids, err = c.TopSqlids(sc); err != nil {
+		fr.Errs["topsqlids"] = err.Error()
+		fr.Sqlids = prev.Sqlids
+	}
+	if fr.Sids, err = c.TopSids(sc); err != nil {
+		fr.Errs["topsids"] = err.Error()
+		fr.Sids = prev.Sids
+	}
+	if fr.Events, err = c.TopEvents(sc); err != nil {
+		fr.Errs["events"] = err.Error()
+		fr.Events = prev.Events
+	}
+	if fr.Sqls, err = c.Sqls(fr.Sqlids); err != nil {
+		fr.Errs["sqltext"] = err.Error()
+		fr.Sqls = prev.Sqls
+	}
+	fr.Bucket = bucketSecs(fr.Window)
+	if fr.Activity, err = c.Activity(sc, fr.Bucket); err != nil {
+		fr.Errs["activity"] = err.Error()
+		fr.Activity = prev.Activity
 	}
-	for i := len(sids); i < 5; i++ {
-		fmt.Print(xy(sF.x, sF.y+i), "                  ")
+	// the lock chains are the ones of now, a frame of AWR has none
+	if s.live() {
+		if fr.Blockers, err = c.Blockers(); err != nil {
+			fr.Errs["blockers"] = err.Error()
+			fr.Blockers = prev.Blockers
+		}
+	}
+	if len(panels) > 0 {
+		fr.Panels = make(map[string][][]interface{})
+		collectPanels(c, sc, &fr, &prev)
 	}
+	return fr
 }
 
-func printTopEvents(events []EventRow, S map[string]F) {
-	F1, _ := S["events"]
-	F2, _ := S["waitclasses"]
-	for i, ev := range events {
-		val1 := fmt.Sprintf("%3d%% | %-30s", ev.Seconds*100/300, ev.Event.String)
-		val2 := fmt.Sprintf("%-14s", ev.Wait_class.String)
-		fmt.Print(xy(F1.x+F1.w-len(val1), F1.y+i), val1)
-		fmt.Print(xy(F2.x+F2.w-len(val2), F2.y+i), val2)
+func printFrame(fr frame, sel selection, S map[string]F) {
+	if _, ok := S["metrics"]; !ok {
+		return
+	}
+	secs := fr.windowSecs()
+	printMetrics(fr.Metrics, S)
+	src := "ASH"
+	if fr.From != nil {
+		src = "AWR"
+	}
+	fmt.Fprint(out, xy(S["screen"].w-15, 1), fg(17), fmt.Sprintf("[ %s %3dm ]", src, fr.Window), fg(16))
+	printTopSqlids(fr.Sqlids, secs, sel.at(panelSqlids), S)
+	printTopSids(fr.Sids, secs, sel.at(panelSids), S)
+	printTopEvents(fr.Events, secs, sel.at(panelEvents), S)
+	printActivity(fr, S)
+	printAlerts(S)
+	printBlocked(fr, S)
+	printSqls(fr.Sqls, S)
+	printPanels(fr, S)
+	printStatus(fr, S)
+}
+
+// errBoxes maps a failing panel to the box which gets the stale marker
+var errBoxes = map[string]string{
+	"metrics": "mcol0", "topsqlids": "topsqlids", "topsids": "topsids",
+	"events": "events", "sqltext": "sqlid", "activity": "activity",
+}
+
+// printStatus marks stale panels and shows the first error in the status line
+func printStatus(fr frame, S map[string]F) {
+	for p, b := range errBoxes {
+		f, ok := S[b]
+		if !ok {
+			continue
+		}
+		if _, ok := fr.Errs[p]; ok {
+			fmt.Fprint(out, xy(f.x, f.y+f.h), fg(160), " STALE ", fg(16))
+		} else {
+			fmt.Fprint(out, xy(f.x, f.y+f.h), "───────")
+		}
+	}
+	msg := ""
+	for _, p := range errPanels {
+		if e, ok := fr.Errs[p]; ok {
+			if msg == "" {
+				msg = fmt.Sprintf("%s %s: %s", fr.Time.Format("15:04:05"), p, oneline(e))
+			} else {
+				msg += " (more in the log)"
+				break
+			}
+		}
+	}
+	scr := S["screen"]
+	fmt.Fprint(out, xy(1, scr.h), fg(160), fit(msg, scr.w-1), fg(16))
+}
+
+// highlight marks the selected row
+func highlight(s string, on bool) string {
+	if !on {
+		return s
+	}
+	return "\x1b[7m" + s + "\x1b[27m"
+}
+
+// windows offered by the +/- hotkeys
+var ashWindows = []int{1, 5, 15, 60}
+
+func nextWindow(cur int, up bool) int {
+	if up {
+		for _, w := range ashWindows {
+			if w > cur {
+				return w
+			}
+		}
+		return cur
+	}
+	for i := len(ashWindows) - 1; i >= 0; i-- {
+		if ashWindows[i] < cur {
+			return ashWindows[i]
+		}
+	}
+	return cur
+}
+
+func printTopSqlids(sqlidrows []SqlidRow, secs int, hl int, S map[string]F) {
+	sF, _ := S["topsqlids"]
+	for i := 0; i < sF.h; i++ {
+		val := ""
+		if i < len(sqlidrows) {
+			sqlid := sqlidrows[i]
+			val = fmt.Sprintf("%3d%% | %*s", sqlid.Seconds*100/secs, sF.w-7, fmt.Sprintf("%s (%d)", sqlid.Sql_id.String, sqlid.Sql_child_number.Int64))
+		}
+		fmt.Fprint(out, xy(sF.x, sF.y+i), highlight(fit(val, sF.w), i == hl))
+		if iF, ok := S["sqlinst"]; ok {
+			inst := ""
+			if i < len(sqlidrows) {
+				inst = fmt.Sprintf("%*d", iF.w, sqlidrows[i].Inst_id)
+			}
+			fmt.Fprint(out, xy(iF.x, iF.y+i), fit(inst, iF.w))
+		}
 	}
-	for i := len(events); i < 5; i++ {
-		fmt.Print(xy(F1.x, F1.y+i), "                                      ")
-		fmt.Print(xy(F2.x, F2.y+i), "              ")
+}
+
+func printTopSids(sids []SessionRow, secs int, hl int, S map[string]F) {
+	sF, _ := S["topsids"]
+	for i := 0; i < sF.h; i++ {
+		val := ""
+		if i < len(sids) {
+			sid := sids[i]
+			val = fmt.Sprintf("%3d%% | %*s", sid.Seconds*100/secs, sF.w-7, fmt.Sprintf("%s,%s", sid.Sid.String, sid.Serial.String))
+		}
+		fmt.Fprint(out, xy(sF.x, sF.y+i), highlight(fit(val, sF.w), i == hl))
+		if iF, ok := S["sidinst"]; ok {
+			inst := ""
+			if i < len(sids) {
+				inst = fmt.Sprintf("%*d", iF.w, sids[i].Inst_id)
+			}
+			fmt.Fprint(out, xy(iF.x, iF.y+i), fit(inst, iF.w))
+		}
 	}
+}
 
+func printTopEvents(events []EventRow, secs int, hl int, S map[string]F) {
+	F1, _ := S["events"]
+	F2, wc := S["waitclasses"]
+	for i := 0; i < F1.h; i++ {
+		val1, val2 := "", ""
+		if i < len(events) {
+			ev := events[i]
+			val1 = fmt.Sprintf("%3d%% | %s", ev.Seconds*100/secs, ev.Event.String)
+			val2 = ev.Wait_class.String
+		}
+		c1, c2 := 16, 16
+		if i < len(events) {
+			c1 = alarms.events[events[i].Event.String]
+			c2 = alarms.classes[eventClass(events[i])]
+			c1 = worse(c1, c2)
+			if c1 == 0 {
+				c1 = 16
+			}
+			if c2 == 0 {
+				c2 = 16
+			}
+		}
+		fmt.Fprint(out, xy(F1.x, F1.y+i), fg(c1), highlight(fit(val1, F1.w), i == hl), fg(16))
+		if wc {
+			fmt.Fprint(out, xy(F2.x, F2.y+i), fg(c2), fit(val2, F2.w), fg(16))
+		}
+	}
 }
 
 func printSqls(sqls []SqltextRow, S map[string]F) {
 	sF1, _ := S["sqlid"]
 	sF2, _ := S["phv"]
 	sF3, _ := S["sqltext"]
-	for i, sql := range sqls {
-		val1 := fmt.Sprintf("%-12s", sql.Sql_id)
-		val2 := fmt.Sprintf("%11d", sql.Plan.Int64)
-		val3 := fmt.Sprintf("%-78s", sql.Sqltext)
-		fmt.Print(xy(sF1.x+sF1.w-len(val1), sF1.y+i), val1)
-		fmt.Print(xy(sF2.x+sF2.w-len(val2), sF2.y+i), val2)
-		fmt.Print(xy(sF3.x+sF3.w-len(val3), sF3.y+i), val3)
-	}
-	for i := len(sqls); i < 5; i++ {
-		fmt.Print(xy(sF1.x, sF1.y+i), fmt.Sprintf("%13s", " ")) // "!********0*!")
-		fmt.Print(xy(sF2.x, sF2.y+i), fmt.Sprintf("%11s", " ")) //"!********0**!")
-		fmt.Print(xy(sF3.x, sF3.y+i), fmt.Sprintf("%78s", " ")) // "!********1*********2*********3*********4*********5*********6*********7*!")
+	for i := 0; i < sF1.h; i++ {
+		val1, val2, val3 := "", "", ""
+		if i < len(sqls) {
+			sql := sqls[i]
+			val1 = sql.Sql_id
+			val2 = fmt.Sprintf("%11d", sql.Plan.Int64)
+			val3 = sql.Sqltext
+		}
+		fmt.Fprint(out, xy(sF1.x, sF1.y+i), fit(val1, sF1.w))
+		fmt.Fprint(out, xy(sF2.x, sF2.y+i), fit(val2, sF2.w))
+		fmt.Fprint(out, xy(sF3.x, sF3.y+i), fitdots(val3, sF3.w))
 	}
 }
 
 func printMetrics(im instanceMetrics, S map[string]F) {
-	fmt.Print(xy(20, 1), fg(17), "[ ", im.iname, " ", im.mtime, " ] ", fg(16)) // c216(0xff, 0xff, 0xaf)), bg(234))
+	title := fmt.Sprintf("[ %s %s ]", im.iname, im.mtime)
+	n := alarms.firing()
+	if n > 0 {
+		title = fmt.Sprintf("[ %s %s, %d ALERTS ]", im.iname, im.mtime, n)
+	}
+	// the dashes wipe out a longer title from before, up to the ASH window label
+	f := S["metrics"]
+	wipe := f.x + f.w - 15 - 20 - len([]rune(title))
+	if wipe > 12 {
+		wipe = 12
+	}
+	if wipe < 0 {
+		wipe = 0
+	}
+	if n > 0 && alarms.flash {
+		title = fg(231) + bg(160) + mark(title) + bg(255)
+	}
+	fmt.Fprint(out, xy(20, 1), fg(17), title, fg(16), strings.Repeat("─", wipe)) // c216(0xff, 0xff, 0xaf)), bg(234))
 	printF(S, "cpuutil", fmt.Sprintf("%3.0f%%", im.cpuutil))
 	printF(S, "cpuratio", fmt.Sprintf("%3.0f%%", im.cpuratio))
 	printF(S, "aas", fmt.Sprintf("%5.1f", im.aas))
@@ -391,19 +1052,12 @@ func printMetrics(im instanceMetrics, S map[string]F) {
 	printF(S, "fullindscan", fmt.Sprintf("%7.0f", im.fullindscan))
 	printF(S, "totindscan", fmt.Sprintf("%7.0f", im.totindscan))
 	printF(S, "tottabscan", fmt.Sprintf("%7.0f", im.tottabscan))
+	printSparks(S)
 }
 
+// logerr appends to the log file set up in main
 func logerr(e string) {
-	f, err := os.OpenFile("odash.log", os.O_APPEND|os.O_WRONLY, 0600)
-	if err != nil {
-		panic(err)
-	}
-
-	defer f.Close()
-
-	if _, err = f.WriteString(e + "\n"); err != nil {
-		panic(err)
-	}
+	log.Println("ERR:", e)
 }
 
 // not used right now
@@ -450,85 +1104,67 @@ func getInstanceSummary(db *sqlx.DB) instanceSummary {
 	return is
 }
 
-func ashpoll(db *sqlx.DB) {
-	sessRecs := []SessionRecord{}
-	selectVSession := `select 
-  dbms_utility.get_time() ashtime,
-  sid,
-  serial#,
-  username,
-  machine,
-  program,
-  sql_id,
-  sql_child_number,
-  blocking_session,
-  case when state = 'WAITING' then event else 'ON CPU' end event,
-  case when state = 'WAITING' then wait_class else 'ON CPU' end wait_class,
-  wait_time,
-  seconds_in_wait
-from gv$session
-where 
-  status = 'ACTIVE'
-  and (wait_class != 'Idle' or state != 'WAITING')
-  --and sid != sys_context('userenv', 'sid')`
-	err := db.Select(&sessRecs, selectVSession)
-	if err != nil {
-		log.Println("ERR:", err)
-	}
-}
-
 type SqlidRow struct {
+	Inst_id          int            `db:"INST_ID"`
 	Sql_id           sql.NullString `db:"SQL_ID"`
 	Sql_child_number sql.NullInt64  `db:"SQL_CHILD_NUMBER"`
 	Seconds          int            `db:"SECONDS"`
 }
 
-func ashTopSqlids(db *sqlx.DB) ([]SqlidRow, error) {
+func ashTopSqlids(db *sqlx.DB, sc ashScope) ([]SqlidRow, error) {
 	var sqlidRows []SqlidRow
 	var r SqlidRow
 	rows, err := db.Queryx(`select * from 
-	(select sql_id, sql_child_number, count(*) seconds 
-	 from v$active_session_history 
-	 where sql_id is not null and sample_time >= sysdate-5/1440 group by sql_id,sql_child_number order by 3 desc
+	(select inst_id, sql_id, sql_child_number, count(*)*:1 seconds 
+	 from `+ashFrom(sc)+`
+	 and sql_id is not null
+	 group by inst_id,sql_id,sql_child_number order by 4 desc
 	)
-	where rownum < 6`)
+	where rownum <= :2`, sampleSecs(sc), sc.rows)
 	if err != nil && err != sql.ErrNoRows {
 		return sqlidRows, err
 	}
+	defer rows.Close()
 	for rows.Next() {
-		rows.StructScan(&r)
+		if err = rows.StructScan(&r); err != nil {
+			return sqlidRows, err
+		}
 		if r.Sql_id.Valid && r.Sql_id.String != "" {
 			sqlidRows = append(sqlidRows, r)
 		}
 	}
-	return sqlidRows, nil
+	return sqlidRows, rows.Err()
 }
 
 type SessionRow struct {
+	Inst_id int            `db:"INST_ID"`
 	Sid     sql.NullString `db:"SESSION_ID"`
 	Serial  sql.NullString `db:"SESSION_SERIAL#"`
 	Seconds int            `db:"SECONDS"`
 }
 
-func ashTopSids(db *sqlx.DB) ([]SessionRow, error) {
+func ashTopSids(db *sqlx.DB, sc ashScope) ([]SessionRow, error) {
 	var res []SessionRow
 	var r SessionRow
 	rows, err := db.Queryx(`select * from 
-	(select session_id, session_serial#, count(*) seconds 
-	 from v$active_session_history 
-	 where sample_time >= sysdate-5/1440 group by session_id,session_serial# order by 3 desc
+	(select inst_id, session_id, session_serial#, count(*)*:1 seconds 
+	 from `+ashFrom(sc)+`
+	 group by inst_id,session_id,session_serial# order by 4 desc
 	)
-	where rownum < 6`)
+	where rownum <= :2`, sampleSecs(sc), sc.rows)
 	if err != nil && err != sql.ErrNoRows {
 		return res, err
 	}
+	defer rows.Close()
 	for rows.Next() {
-		rows.StructScan(&r)
+		if err = rows.StructScan(&r); err != nil {
+			return res, err
+		}
 		if r.Sid.Valid && r.Sid.String != "" {
 			res = append(res, r)
 		}
 	}
-	return res, nil
+	return res, rows.Err()
 }
 
 type EventRow struct {
@@ -537,30 +1173,34 @@ type EventRow struct {
 	Seconds    int            `db:"SECONDS"`
 }
 
-func ashTopEvents(db *sqlx.DB) ([]EventRow, error) {
+func ashTopEvents(db *sqlx.DB, sc ashScope) ([]EventRow, error) {
 	var res []EventRow
 	var r EventRow
 	rows, err := db.Queryx(`select * from 
-	(select decode(session_state,'ON CPU',session_state,event) event, wait_class , count(*) seconds
-	 from v$active_session_history
-	 where sample_time >= sysdate-5/1440
+	(select decode(session_state,'ON CPU',session_state,event) event, wait_class , count(*)*:1 seconds
+	 from `+ashFrom(sc)+`
 	 group by decode(session_state,'ON CPU',session_state,event), wait_class order by 3 desc
 	)
-where rownum < 6`)
+where rownum <= :2`, sampleSecs(sc), sc.rows)
 	if err != nil && err != sql.ErrNoRows {
 		return res, err
 	}
+	defer rows.Close()
 	for rows.Next() {
-		rows.StructScan(&r)
+		if err = rows.StructScan(&r); err != nil {
+			return res, err
+		}
 		if r.Event.Valid {
 			res = append(res, r)
 		}
 	}
-	return res, nil
+	return res, rows.Err()
 }
 
 type SqltextRow struct {
+	Inst_id         int           `db:"INST_ID"`
 	Sql_id          string        `db:"SQL_ID"`
+	Child_number    sql.NullInt64 `db:"CHILD_NUMBER"`
 	Plan            sql.NullInt64 `db:"PLAN_HASH_VALUE"`
 	Sqltext         string        `db:"SQL_TEXT"`
 	Parsing_User_Id sql.NullInt64 `db:"PARSING_USER_ID"`
@@ -571,46 +1211,50 @@ func getSqls(db *sqlx.DB, sql_ids []SqlidRow) ([]SqltextRow, error) {
 	var r SqltextRow
 	for _, sqlid := range sql_ids {
 		if sqlid.Sql_id.Valid {
-			err := db.QueryRowx("select distinct sql_id, plan_hash_value, sql_text, parsing_user_id from v$sql where sql_id = :1 and child_number = :2", sqlid.Sql_id.String, sqlid.Sql_child_number.Int64).StructScan(&r)
+			err := db.QueryRowx("select inst_id, sql_id, child_number, plan_hash_value, sql_text, parsing_user_id from gv$sql where sql_id = :1 and child_number = :2 and inst_id = :3", sqlid.Sql_id.String, sqlid.Sql_child_number.Int64, sqlid.Inst_id).StructScan(&r)
+			if err == sql.ErrNoRows {
+				// aged out of the shared pool, the others may still be there
+				continue
+			}
 			if err != nil {
-				// just hide this error from caller
-				return res, nil
+				return res, err
 			}
 
-			r.Sqltext = trimsql(r.Sqltext)
-			if len(r.Sqltext) > 76 {
-				r.Sqltext = r.Sqltext[:76] + ".."
-			}
+			r.Sqltext = trimsql(oneline(r.Sqltext))
 			res = append(res, r)
 		}
 	}
 	return res, nil
 }
 
-func getMetrics(db *sqlx.DB) (instanceMetrics, error) {
-	var im instanceMetrics
-	var iname string
-
-	err := db.Get(&iname, "select instance_name from v$instance")
-	if err != nil {
-		im.iname = "?"
-	} else {
-		im.iname = iname
-	}
-
-	im.mtime = time.Now().Format("15:04:05")
-	rows, err := db.Query(`select metric_name, value
-from v$sysmetric 
-where group_id=3 and metric_name in (
+// the v$sysmetric metrics shown in the header
+const sysmetricNames = `
   'Average Active Sessions', 'Host CPU Utilization (%)', 'Database CPU Time Ratio',
   'Executions Per Sec', 'User Calls Per Sec', 'User Transaction Per Sec',
   'Logical Reads Per Sec', 'Physical Reads Per Sec', 'Physical Writes Per Sec',
   'DB Block Gets Per Sec', 'DB Block Changes Per Sec', 'Redo Generated Per Sec',
   'Full Index Scans Per Sec', 'Total Index Scans Per Sec', 'Total Table Scans Per Sec'
-)`)
+`
+
+// getMetrics reads the metrics of the instances in sc; for several instances
+// the rates are summed up and the percentages averaged.
+func getMetrics(db *sqlx.DB, sc ashScope) (instanceMetrics, error) {
+	if !sc.live() {
+		return histMetrics(db, sc)
+	}
+	var im instanceMetrics
+	im.iname, im.startup, im.inst = instanceName(db, sc)
+	im.cpus = cpuCount(db, sc)
+	im.mtime = time.Now().Format("15:04:05")
+	rows, err := db.Query(`select metric_name,
+  decode(metric_name, 'Host CPU Utilization (%)', avg(value), 'Database CPU Time Ratio', avg(value), sum(value)) value
+from gv$sysmetric 
+where group_id=3 and ` + instFilter(sc) + ` and metric_name in (` + sysmetricNames + `)
+group by metric_name`)
 	if err != nil {
 		return im, err
 	}
+	defer rows.Close()
 	for rows.Next() {
 		var (
 			nam string
@@ -620,40 +1264,69 @@ where group_id=3 and metric_name in (
 		if err != nil {
 			return im, err
 		}
-		switch nam {
-		case "Host CPU Utilization (%)":
-			im.cpuutil = val
-		case "Database CPU Time Ratio":
-			im.cpuratio = val
-		case "Average Active Sessions":
-			im.aas = val
-		case "Executions Per Sec":
-			im.execs = val
-		case "User Calls Per Sec":
-			im.calls = val
-		case "User Transaction Per Sec":
-			im.tnxs = val
-		case "Logical Reads Per Sec":
-			im.lios = val
-		case "Physical Reads Per Sec":
-			im.phyrd = val
-		case "Physical Writes Per Sec":
-			im.phywr = val
-		case "DB Block Gets Per Sec":
-			im.blkgets = val
-		case "DB Block Changes Per Sec":
-			im.blkchng = val
-		case "Redo Generated Per Sec":
-			im.redomb = val
-		case "Full Index Scans Per Sec":
-			im.fullindscan = val
-		case "Total Index Scans Per Sec":
-			im.totindscan = val
-		case "Total Table Scans Per Sec":
-			im.tottabscan = val
-		}
-	}
-	return im, nil
+		im.set(nam, val)
+	}
+	return im, rows.Err()
+}
+
+// instanceName returns the name, the startup time and the inst_id of the instances in sc
+func instanceName(db *sqlx.DB, sc ashScope) (string, string, int) {
+	var inst struct {
+		Name    string `db:"INSTANCE_NAME"`
+		Startup string `db:"STARTUP"`
+		Id      int    `db:"INST_ID"`
+	}
+	err := db.Get(&inst, `select decode(count(*), 1, max(instance_name), 'ALL('||count(*)||')') instance_name,
+  to_char(max(startup_time), 'YYYY-MM-DD HH24:MI:SS') startup,
+  decode(count(*), 1, max(inst_id), 0) inst_id
+from gv$instance where `+instFilter(sc))
+	if err != nil {
+		return "?", "", 0
+	}
+	return inst.Name, inst.Startup, inst.Id
+}
+
+// cpuCount returns the number of CPUs of the instances in sc, 0 if unknown
+func cpuCount(db *sqlx.DB, sc ashScope) int {
+	var n sql.NullInt64
+	db.Get(&n, `select sum(value) from gv$osstat where stat_name = 'NUM_CPUS' and `+instFilter(sc))
+	return int(n.Int64)
+}
+
+// set stores the value of a sysmetric
+func (im *instanceMetrics) set(nam string, val float32) {
+	switch nam {
+	case "Host CPU Utilization (%)":
+		im.cpuutil = val
+	case "Database CPU Time Ratio":
+		im.cpuratio = val
+	case "Average Active Sessions":
+		im.aas = val
+	case "Executions Per Sec":
+		im.execs = val
+	case "User Calls Per Sec":
+		im.calls = val
+	case "User Transaction Per Sec":
+		im.tnxs = val
+	case "Logical Reads Per Sec":
+		im.lios = val
+	case "Physical Reads Per Sec":
+		im.phyrd = val
+	case "Physical Writes Per Sec":
+		im.phywr = val
+	case "DB Block Gets Per Sec":
+		im.blkgets = val
+	case "DB Block Changes Per Sec":
+		im.blkchng = val
+	case "Redo Generated Per Sec":
+		im.redomb = val
+	case "Full Index Scans Per Sec":
+		im.fullindscan = val
+	case "Total Index Scans Per Sec":
+		im.totindscan = val
+	case "Total Table Scans Per Sec":
+		im.tottabscan = val
+	}
 }
 
 func db_get_stats(db *sqlx.DB) (map[string]int64, error) {
@@ -708,42 +1381,35 @@ func trimsql(s string) string {
 		}
 		res += c
 	}
-	if string(res[0]) == " " {
+	if len(res) > 0 && res[0] == ' ' {
 		res = res[1:]
 	}
 	return res
 }
 
+// conv216 is the step of the color cube nearest to a 0-255 component
 func conv216(i int) int {
-	cnt := 0
-	for {
-		if i <= 0 {
-			return cnt
-		} else if i <= 95 {
-			i -= 95
-			cnt += 1
-		} else {
-			i -= 40
-			cnt += 1
-		}
+	switch {
+	case i < 48:
+		return 0
+	case i < 115:
+		return 1
 	}
-	return cnt
+	return (i - 35) / 40
 }
 
+// c216 is the xterm-256 color nearest to r, g, b: a cube color or a gray
 func c216(r, g, b int) int {
-	if r == g && g == b && r != 0 && r != 255 {
-		return 16 + 216 + r
-	} else {
-		return 16 + conv216(r)*36 + conv216(g)*6 + conv216(b) + 1
+	c := 16 + conv216(r)*36 + conv216(g)*6 + conv216(b)
+	avg := (r + g + b) / 3
+	gray := 232 + (avg-3)/10
+	if avg < 8 {
+		gray = 16 // black of the cube
+	} else if avg > 238 {
+		gray = 231 // white of the cube
 	}
-}
-
-/*
-func c216(r, g, b int) ui.Attribute {
-	if r == g && g == b && r != 0 && r != 255 {
-		return (ui.Attribute)(16 + 216 + r)
-	} else {
-		return (ui.Attribute)(16 + conv216(r)*36 + conv216(g)*6 + conv216(b) + 1) // +1 is a temporary dirty hack
+	if dist(r, g, b, xtermRGB(gray)) < dist(r, g, b, xtermRGB(c)) {
+		return gray
 	}
+	return c
 }
-*/
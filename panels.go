@@ -0,0 +1,274 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// customPanel is a table panel of the -config file, filled by a query
+// of the user. Its columns are the ones of the query, in order.
+// The binds :window_minutes and :inst_id (0 for all instances) are
+// supplied if the statement has them.
+type customPanel struct {
+	Sql     string     `json:"sql"`
+	Refresh int        `json:"refresh,omitempty"` // seconds, 0 for every refresh of the dashboard
+	Columns []panelCol `json:"columns"`
+}
+
+type panelCol struct {
+	Title  string `json:"title"`
+	Width  int    `json:"width,omitempty"`  // 0 to share the width left with the other such columns
+	Format string `json:"format,omitempty"` // fmt verb, %d and %x round numbers to integers
+}
+
+// the panels of the config by name
+var panels = map[string]customPanel{}
+
+// most rows a panel query fetches
+const maxPanelRows = 100
+
+// panelRun is when a panel query ran last and for what scope
+type panelRun struct {
+	t  time.Time
+	sc ashScope
+}
+
+var panelRuns = map[string]panelRun{}
+
+// panelsShown are the custom panels the layout placed, nil for all of them
+var panelsShown map[string]bool
+
+// showPanels sets panelsShown from S, it returns true if a panel came into view
+func showPanels(S map[string]F) bool {
+	shown := make(map[string]bool)
+	added := false
+	for name := range panels {
+		if _, ok := S["box."+name]; ok {
+			shown[name] = true
+			added = added || panelsShown != nil && !panelsShown[name]
+		}
+	}
+	panelsShown = shown
+	return added
+}
+
+// a column format: flags, width and precision of one verb, text and %% around it
+var formatRe = regexp.MustCompile(`^(?:[^%]|%%)*%[-+# 0]*[0-9]*(?:\.[0-9]+)?([vdxXobeEfFgGsq])(?:[^%]|%%)*$`)
+
+// formatVerb is the verb of a column format, an error if there isn't exactly one
+func formatVerb(format string) (byte, error) {
+	m := formatRe.FindStringSubmatch(format)
+	if m == nil {
+		return 0, fmt.Errorf("bad format %q, it takes one verb like %%d, %%.1f or %%-10s", format)
+	}
+	return m[1][0], nil
+}
+
+// addPanels makes the custom panels known to the layout, the status line
+// and the STALE markers. Their fields are S["name.N"] for column N.
+func addPanels(ps map[string]customPanel) error {
+	for name, p := range ps {
+		if _, ok := boxes[name]; ok {
+			return fmt.Errorf("panel %q: the name is taken by a built-in panel", name)
+		}
+		if strings.TrimSpace(p.Sql) == "" {
+			return fmt.Errorf("panel %q: no sql", name)
+		}
+		if len(p.Columns) == 0 {
+			return fmt.Errorf("panel %q: no columns", name)
+		}
+		var cols []boxCol
+		for i, c := range p.Columns {
+			if c.Title == "" {
+				return fmt.Errorf("panel %q: column %d has no title", name, i+1)
+			}
+			cols = append(cols, boxCol{fmt.Sprintf("%s.%d", name, i), c.Title})
+		}
+		boxes[name] = cols
+		panels[name] = p
+		errPanels = append(errPanels[:len(errPanels)-1], "panel."+name, "record")
+		errBoxes["panel."+name] = cols[0].key
+	}
+	return nil
+}
+
+// withPanels is l with a row of the custom panels above the alerts
+func withPanels(l screenLayout) screenLayout {
+	var names []string
+	for name := range panels {
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return l
+	}
+	sort.Strings(names)
+	var row layoutRow
+	for _, name := range names {
+		row.Panels = append(row.Panels, layoutPanel{Panel: name})
+	}
+	n := len(l.Rows) - 1
+	rows := append([]layoutRow{}, l.Rows[:n]...)
+	return screenLayout{append(append(rows, row), l.Rows[n:]...)}
+}
+
+// panelBinds are the binds the statement uses
+func panelBinds(stmt string, sc ashScope, inst int) []interface{} {
+	var args []interface{}
+	seen := make(map[string]bool)
+	for _, t := range sqlTokens(stmt) {
+		if t.kind != tokWord || !strings.HasPrefix(t.s, ":") {
+			continue
+		}
+		name := strings.ToLower(t.s[1:])
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		switch name {
+		case "window_minutes":
+			args = append(args, sql.Named(name, sc.minutes))
+		case "inst_id":
+			args = append(args, sql.Named(name, inst))
+		}
+	}
+	return args
+}
+
+// queryPanel runs the statement of a panel. Numbers come back as float64,
+// dates as text, so the rows look the same after a -record and -replay.
+func (c *oracleCollector) queryPanel(p customPanel, sc ashScope) ([][]interface{}, error) {
+	inst := sc.inst
+	if inst < 0 {
+		if err := c.db.Get(&inst, "select to_number(sys_context('userenv', 'instance')) from dual"); err != nil {
+			return nil, err
+		}
+	}
+	rows, err := c.db.Query(p.Sql, panelBinds(p.Sql, sc, inst)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	var res [][]interface{}
+	for rows.Next() && len(res) < maxPanelRows {
+		vals := make([]interface{}, len(types))
+		ptrs := make([]interface{}, len(types))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err = rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		for i, v := range vals {
+			vals[i] = panelValue(v, types[i].DatabaseTypeName())
+		}
+		res = append(res, vals)
+	}
+	return res, rows.Err()
+}
+
+func panelValue(v interface{}, dbtype string) interface{} {
+	switch x := v.(type) {
+	case nil, float64:
+		return v
+	case int64:
+		return float64(x)
+	case time.Time:
+		return x.Format("2006-01-02 15:04:05")
+	case []byte:
+		return string(x)
+	}
+	s := fmt.Sprint(v)
+	if dbtype == "NUMBER" {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	return s
+}
+
+// collectPanels runs the queries of the panels shown which are due, the
+// others keep the rows of prev. A query is due after its refresh seconds
+// or when the window or instance shown changed.
+func collectPanels(c Collector, sc ashScope, fr, prev *frame) {
+	for name, p := range panels {
+		if panelsShown != nil && !panelsShown[name] {
+			continue
+		}
+		last, ran := panelRuns[name]
+		rows, ok := prev.Panels[name]
+		if ran && ok && last.sc == sc && time.Since(last.t) < time.Duration(p.Refresh)*time.Second {
+			fr.Panels[name] = rows
+			continue
+		}
+		var err error
+		if rows, err = c.PanelRows(name, p, sc); err != nil {
+			fr.Errs["panel."+name] = err.Error()
+			rows = prev.Panels[name]
+		} else {
+			panelRuns[name] = panelRun{time.Now(), sc}
+		}
+		fr.Panels[name] = rows
+	}
+}
+
+// formatCell formats a value of a panel row. Integer verbs round numbers,
+// %s and %q take them as text; text is shown as it is with a number verb.
+func formatCell(v interface{}, format string) string {
+	if v == nil {
+		return ""
+	}
+	verb, err := formatVerb(format)
+	if err != nil {
+		verb = 'v'
+		format = "%v"
+	}
+	x, num := v.(float64)
+	switch {
+	case num && strings.IndexByte("dxXob", verb) >= 0:
+		return fmt.Sprintf(format, int64(x))
+	case num && strings.IndexByte("eEfFgG", verb) >= 0:
+		return fmt.Sprintf(format, x)
+	case num:
+		return fmt.Sprintf(format, strconv.FormatFloat(x, 'f', -1, 64))
+	case verb == 's' || verb == 'q' || verb == 'v':
+		return fmt.Sprintf(format, v)
+	}
+	return fmt.Sprint(v)
+}
+
+// printPanels fills the custom panels on the screen, numbers are right-aligned
+func printPanels(fr frame, S map[string]F) {
+	for name, p := range panels {
+		if _, ok := S["box."+name]; !ok {
+			continue
+		}
+		rows := fr.Panels[name]
+		for i, c := range p.Columns {
+			f := S[fmt.Sprintf("%s.%d", name, i)]
+			for r := 0; r < f.h; r++ {
+				val := ""
+				if r < len(rows) && i < len(rows[r]) {
+					v := rows[r][i]
+					if val = formatCell(v, c.Format); isNumber(v) {
+						val = fmt.Sprintf("%*s", f.w, val)
+					}
+				}
+				fmt.Fprint(out, xy(f.x, f.y+r), fitdots(val, f.w))
+			}
+		}
+	}
+}
+
+func isNumber(v interface{}) bool {
+	_, ok := v.(float64)
+	return ok
+}
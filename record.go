@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// recorder appends every refreshed frame to a JSON lines file
+// which can be fed back with -replay (or -fixture).
+type recorder struct {
+	f   *os.File
+	enc *json.Encoder
+}
+
+func newRecorder(dir string) (*recorder, error) {
+	fname := filepath.Join(dir, "oradash-"+time.Now().Format("20060102-150405")+".jsonl")
+	f, err := os.OpenFile(fname, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &recorder{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (r *recorder) write(fr frame) error {
+	return r.enc.Encode(fr)
+}
+
+func (r *recorder) Close() error {
+	return r.f.Close()
+}
+
+// newReplayCollector loads a recording; unlike a fixture it stops at the last frame
+func newReplayCollector(fname string) (*fixtureCollector, error) {
+	c, err := newFixtureCollector(fname)
+	if err != nil {
+		return nil, err
+	}
+	c.once = true
+	return c, nil
+}
+
+// delay returns how long the current frame was on screen when it was recorded.
+// At the end of a replay the last frame stays until the user quits.
+func (c *fixtureCollector) delay(speed float64) time.Duration {
+	d := 10 * time.Second
+	if c.done() {
+		return 24 * time.Hour
+	}
+	if c.idx >= 0 && c.idx+1 < len(c.frames) {
+		if dt := c.frames[c.idx+1].Time.Sub(c.frames[c.idx].Time); dt > 0 {
+			d = dt
+		}
+	}
+	return time.Duration(float64(d) / speed)
+}
+
+// done tells if a replay is at its last frame
+func (c *fixtureCollector) done() bool {
+	return c.once && c.idx+1 >= len(c.frames)
+}
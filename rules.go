@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A rule file has one threshold per line, # starts a comment:
+//
+//	aas > cpu_count
+//	redomb > 50 warn
+//	event:log file sync > 30%
+//	class:User I/O > 2
+//
+// The left side is a metric key from metricCells (values in the units shown),
+// event:<name> or class:<wait class> for the AAS of an event or a wait class,
+// or their share of all activity if the limit ends with %. The limit is a
+// number or cpu_count. A rule is crit unless it ends with warn.
+
+// alert levels are their colors
+const (
+	levelWarn = 208
+	levelCrit = 160
+)
+
+type rule struct {
+	text  string
+	lhs   string
+	op    string
+	rhs   float64
+	cpus  bool // the limit is cpu_count
+	pct   bool // the limit is a share of activity
+	level int
+}
+
+var ruleRe = regexp.MustCompile(`^(.+?)\s*(>=|<=|>|<)\s*(\S+?)(%?)(?:\s+(warn|crit))?$`)
+
+func parseRule(line string) (rule, error) {
+	m := ruleRe.FindStringSubmatch(line)
+	if m == nil {
+		return rule{}, fmt.Errorf("can't parse %q", line)
+	}
+	r := rule{text: line, lhs: m[1], op: m[2], pct: m[4] == "%", level: levelCrit}
+	if m[5] == "warn" {
+		r.level = levelWarn
+	}
+	if m[3] == "cpu_count" {
+		r.cpus = true
+	} else if v, err := strconv.ParseFloat(m[3], 64); err == nil {
+		r.rhs = v
+	} else {
+		return r, fmt.Errorf("%q: the limit must be a number or cpu_count", line)
+	}
+	if strings.HasPrefix(r.lhs, "event:") || strings.HasPrefix(r.lhs, "class:") {
+		return r, nil
+	}
+	if r.pct {
+		return r, fmt.Errorf("%q: only events and wait classes have shares", line)
+	}
+	for _, c := range metricCells {
+		if c.key == r.lhs {
+			return r, nil
+		}
+	}
+	return r, fmt.Errorf("%q: unknown metric %s", line, r.lhs)
+}
+
+func loadRules(fname string) ([]rule, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rules []rule
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		if line == "" {
+			continue
+		}
+		r, err := parseRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", fname, n, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, sc.Err()
+}
+
+// eval returns the value and the limit of r in fr and if r fires
+func (r rule) eval(fr frame) (val, limit float64, fires bool) {
+	limit = r.rhs
+	if r.cpus {
+		if fr.Metrics.cpus == 0 {
+			return 0, 0, false
+		}
+		limit = float64(fr.Metrics.cpus)
+	}
+	switch {
+	case strings.HasPrefix(r.lhs, "event:"):
+		name := strings.TrimPrefix(r.lhs, "event:")
+		for _, ev := range fr.Events {
+			if ev.Event.String == name {
+				val += float64(ev.Seconds)
+			}
+		}
+		val = r.scale(val, fr)
+	case strings.HasPrefix(r.lhs, "class:"):
+		name := strings.TrimPrefix(r.lhs, "class:")
+		if len(fr.Activity) > 0 {
+			for _, a := range fr.Activity {
+				if a.Wait_class == name {
+					val += float64(a.Seconds)
+				}
+			}
+		} else {
+			for _, ev := range fr.Events {
+				if eventClass(ev) == name {
+					val += float64(ev.Seconds)
+				}
+			}
+		}
+		val = r.scale(val, fr)
+	default:
+		val = float64(fr.Metrics.value(r.lhs))
+	}
+	switch r.op {
+	case ">":
+		return val, limit, val > limit
+	case ">=":
+		return val, limit, val >= limit
+	case "<":
+		return val, limit, val < limit
+	}
+	return val, limit, val <= limit
+}
+
+// scale turns ASH seconds into AAS or a share of all activity
+func (r rule) scale(secs float64, fr frame) float64 {
+	if !r.pct {
+		if fr.Window == 0 {
+			return 0
+		}
+		return secs / float64(fr.windowSecs())
+	}
+	total := 0.0
+	if len(fr.Activity) > 0 {
+		for _, a := range fr.Activity {
+			total += float64(a.Seconds)
+		}
+	} else {
+		for _, ev := range fr.Events {
+			total += float64(ev.Seconds)
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return secs / total * 100
+}
+
+// alert is a rule that started or stopped firing
+type alert struct {
+	time    time.Time
+	rule    rule
+	val     float64
+	limit   float64
+	cleared bool
+}
+
+func (a alert) String() string {
+	if a.cleared {
+		return fmt.Sprintf("%s  OK    %s", a.time.Format("15:04:05"), a.rule.text)
+	}
+	lvl := "CRIT"
+	if a.rule.level == levelWarn {
+		lvl = "WARN"
+	}
+	return fmt.Sprintf("%s  %s  %s  (%.1f, limit %.1f)", a.time.Format("15:04:05"), lvl, a.rule.text, a.val, a.limit)
+}
+
+// alertState is what the rules found in the last frame
+type alertState struct {
+	rules   []rule
+	active  map[string]bool // by rule text
+	cells   map[string]int  // metric key -> color
+	events  map[string]int  // event -> color
+	classes map[string]int  // wait class -> color
+	history []alert         // oldest first
+	flash   bool            // the title is shown inverted
+}
+
+var alarms alertState
+
+const maxAlerts = 500
+
+// check evaluates the rules on a frame and records which started or stopped firing
+func (as *alertState) check(fr frame) {
+	if as.active == nil {
+		as.active = make(map[string]bool)
+	}
+	as.cells = make(map[string]int)
+	as.events = make(map[string]int)
+	as.classes = make(map[string]int)
+	for _, r := range as.rules {
+		val, limit, fires := r.eval(fr)
+		if fires {
+			if name, ok := cutPrefix(r.lhs, "event:"); ok {
+				as.events[name] = worse(as.events[name], r.level)
+			} else if name, ok := cutPrefix(r.lhs, "class:"); ok {
+				as.classes[name] = worse(as.classes[name], r.level)
+			} else {
+				as.cells[r.lhs] = worse(as.cells[r.lhs], r.level)
+			}
+		}
+		if fires == as.active[r.text] {
+			continue
+		}
+		as.active[r.text] = fires
+		a := alert{time: fr.Time, rule: r, val: val, limit: limit, cleared: !fires}
+		log.Println("ALERT:", a)
+		as.history = append(as.history, a)
+		if len(as.history) > maxAlerts {
+			as.history = as.history[1:]
+		}
+	}
+}
+
+func cutPrefix(s, prefix string) (string, bool) {
+	if !strings.HasPrefix(s, prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
+
+// worse of two levels, 0 is none
+func worse(a, b int) int {
+	if a == levelCrit || b == levelCrit {
+		return levelCrit
+	}
+	if a == levelWarn || b == levelWarn {
+		return levelWarn
+	}
+	return 0
+}
+
+func (as *alertState) firing() int {
+	n := 0
+	for _, on := range as.active {
+		if on {
+			n++
+		}
+	}
+	return n
+}
+
+// lines returns the history, newest first
+func (as *alertState) lines() []string {
+	var res []string
+	for i := len(as.history) - 1; i >= 0; i-- {
+		res = append(res, as.history[i].String())
+	}
+	return res
+}
+
+func printAlerts(S map[string]F) {
+	f, ok := S["alerts"]
+	if !ok {
+		return
+	}
+	lines := alarms.lines()
+	for i := 0; i < f.h; i++ {
+		l, color := "", 16
+		if i < len(lines) {
+			l = lines[i]
+			if a := alarms.history[len(alarms.history)-1-i]; !a.cleared {
+				color = a.rule.level
+			}
+		}
+		fmt.Fprint(out, xy(f.x, f.y+i), fg(color), fitdots(l, f.w), fg(16))
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import "testing"
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		line string
+		want rule
+		err  string
+	}{
+		{"aas > cpu_count", rule{lhs: "aas", op: ">", cpus: true, level: levelCrit}, ""},
+		{"redomb >= 50 warn", rule{lhs: "redomb", op: ">=", rhs: 50, level: levelWarn}, ""},
+		{"cpuutil<90.5 crit", rule{lhs: "cpuutil", op: "<", rhs: 90.5, level: levelCrit}, ""},
+		{"event:log file sync > 30%", rule{lhs: "event:log file sync", op: ">", rhs: 30, pct: true, level: levelCrit}, ""},
+		{"class:User I/O <= 2 warn", rule{lhs: "class:User I/O", op: "<=", rhs: 2, level: levelWarn}, ""},
+		{"aas > lots", rule{}, `"aas > lots": the limit must be a number or cpu_count`},
+		{"aas > 50%", rule{}, `"aas > 50%": only events and wait classes have shares`},
+		{"nosuch > 1", rule{}, `"nosuch > 1": unknown metric nosuch`},
+		{"aas = 1", rule{}, `can't parse "aas = 1"`},
+		{"aas >", rule{}, `can't parse "aas >"`},
+	}
+	for _, tt := range tests {
+		r, err := parseRule(tt.line)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: got %v, want %q", tt.line, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.line, err)
+			continue
+		}
+		tt.want.text = tt.line
+		if r != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.line, r, tt.want)
+		}
+	}
+}
+
+func TestRuleEval(t *testing.T) {
+	var fr frame
+	fr.Metrics.cpus = 4
+	tests := []struct {
+		line  string
+		aas   float32
+		fires bool
+	}{
+		{"aas > cpu_count", 5, true},
+		{"aas > cpu_count", 4, false},
+		{"aas >= cpu_count", 4, true},
+		{"aas < 1", 0.5, true},
+		{"aas <= 1", 2, false},
+	}
+	for _, tt := range tests {
+		r, err := parseRule(tt.line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fr.Metrics.aas = tt.aas
+		if _, _, fires := r.eval(fr); fires != tt.fires {
+			t.Errorf("%s with aas %v: fires %v", tt.line, tt.aas, fires)
+		}
+	}
+}
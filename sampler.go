@@ -0,0 +1,344 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SessionRecord is an active session of one gv$session sample
+type SessionRecord struct {
+	Inst_id          int            `db:"INST_ID"`
+	Sid              int            `db:"SID"`
+	Serial           int            `db:"SERIAL#"`
+	Username         sql.NullString `db:"USERNAME"`
+	Machine          sql.NullString `db:"MACHINE"`
+	Program          sql.NullString `db:"PROGRAM"`
+	Sql_id           sql.NullString `db:"SQL_ID"`
+	Sql_child_number sql.NullInt64  `db:"SQL_CHILD_NUMBER"`
+	Blocking_session sql.NullString `db:"BLOCKING_SESSION"`
+	Event            sql.NullString `db:"EVENT"`
+	Wait_class       sql.NullString `db:"WAIT_CLASS"`
+	Wait_time        sql.NullString `db:"WAIT_TIME"`
+	Seconds_in_wait  sql.NullString `db:"SECONDS_IN_WAIT"`
+}
+
+// the active sessions the way ASH sees them: on CPU or in a non-idle wait
+const selectVSession = `select
+  inst_id,
+  sid,
+  serial#,
+  username,
+  machine,
+  program,
+  sql_id,
+  sql_child_number,
+  blocking_session,
+  case when state = 'WAITING' then event else 'ON CPU' end event,
+  case when state = 'WAITING' then wait_class end wait_class,
+  wait_time,
+  seconds_in_wait
+from gv$session
+where
+  status = 'ACTIVE'
+  and (wait_class != 'Idle' or state != 'WAITING')
+  and not (inst_id = sys_context('userenv', 'instance') and sid = sys_context('userenv', 'sid'))`
+
+// sessSample is one gv$session sample, t is database time in seconds since 1970
+type sessSample struct {
+	t    int64
+	sess []SessionRecord
+}
+
+// sampler is a poor man's ASH for Standard Edition and databases without the
+// Diagnostics Pack: gv$session sampled every second into a ring buffer.
+// The top panels, the activity chart and the ASH parts of the detail screens
+// are computed from the samples, everything else is the oracleCollector's.
+type sampler struct {
+	*oracleCollector
+	conn   sync.Mutex   // held by a sample while it queries, the connection isn't replaced meanwhile
+	mu     sync.Mutex   // guards the samples and err
+	ring   []sessSample // the oldest sample is overwritten
+	next   int
+	offset int64 // database time - local time, seconds
+	cur    int   // the instance we are connected to, it may change with a reconnect
+	err    error // of the last sample
+	stop   chan struct{}
+}
+
+// newSampler keeps minutes of samples and takes the first one right away
+func newSampler(c *oracleCollector, minutes int) (*sampler, error) {
+	s := &sampler{oracleCollector: c, ring: make([]sessSample, 0, minutes*60), stop: make(chan struct{})}
+	var now int64
+	if err := c.db.Get(&now, "select round((sysdate - date '1970-01-01')*86400) from dual"); err != nil {
+		return nil, err
+	}
+	s.offset = now - time.Now().Unix()
+	if err := c.db.Get(&s.cur, "select to_number(sys_context('userenv', 'instance')) from dual"); err != nil {
+		return nil, err
+	}
+	s.poll()
+	safe(s.run)
+	return s, nil
+}
+
+func (s *sampler) run() {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-t.C:
+			s.poll()
+		}
+	}
+}
+
+func (s *sampler) poll() {
+	smp := sessSample{t: time.Now().Unix() + s.offset}
+	s.conn.Lock()
+	err := s.db.Select(&smp.sess, selectVSession)
+	s.conn.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err = err; err != nil {
+		log.Println("sample:", err)
+		return
+	}
+	if len(s.ring) < cap(s.ring) {
+		s.ring = append(s.ring, smp)
+	} else {
+		s.ring[s.next] = smp
+	}
+	s.next = (s.next + 1) % cap(s.ring)
+}
+
+// Reconnect waits for the sample in flight to take the new connection,
+// which may be to another instance of a RAC cluster
+func (s *sampler) Reconnect() error {
+	s.conn.Lock()
+	defer s.conn.Unlock()
+	if err := s.oracleCollector.Reconnect(); err != nil {
+		return err
+	}
+	var cur int
+	if err := s.db.Get(&cur, "select to_number(sys_context('userenv', 'instance')) from dual"); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.cur = cur
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *sampler) Close() error {
+	close(s.stop)
+	s.conn.Lock()
+	defer s.conn.Unlock()
+	return s.oracleCollector.Close()
+}
+
+// Scope is as much of sc as was sampled, secs is set while the ring fills
+func (s *sampler) Scope(sc ashScope) ashScope {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.ring) == 0 {
+		return sc
+	}
+	first, last := s.ring[0].t, s.ring[(s.next+len(s.ring)-1)%len(s.ring)].t
+	if len(s.ring) == cap(s.ring) {
+		first = s.ring[s.next].t
+	}
+	if secs := int(last-first) + 1; secs < sc.minutes*60 {
+		sc.minutes, sc.secs = (secs+59)/60, secs
+	}
+	return sc
+}
+
+// each calls f with the time of every session of the window and instance of sc
+func (s *sampler) each(sc ashScope, f func(t int64, r *SessionRecord)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst := sc.inst
+	if inst < 0 {
+		inst = s.cur
+	}
+	var last int64
+	for _, smp := range s.ring {
+		if smp.t > last {
+			last = smp.t
+		}
+	}
+	for _, smp := range s.ring {
+		if smp.t <= last-int64(sc.minutes)*60 {
+			continue
+		}
+		for i := range smp.sess {
+			if r := &smp.sess[i]; inst == 0 || r.Inst_id == inst {
+				f(smp.t, r)
+			}
+		}
+	}
+}
+
+// ranked are the keys of a tally, the most seconds first
+func ranked(m map[interface{}]int, n int) []interface{} {
+	var keys []interface{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ci, cj := m[keys[i]], m[keys[j]]
+		return ci > cj || ci == cj && fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+	})
+	if n > 0 && len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+type sqlKey struct {
+	inst  int
+	sqlid string
+	child int64
+}
+
+type sidKey struct {
+	inst, sid, serial int
+}
+
+type eventKey struct {
+	event, class string
+}
+
+// topSqls is the TOP SQL_ID of the sessions match lets through
+func (s *sampler) topSqls(sc ashScope, n int, match func(r *SessionRecord) bool) []SqlidRow {
+	m := make(map[interface{}]int)
+	s.each(sc, func(t int64, r *SessionRecord) {
+		if r.Sql_id.Valid && r.Sql_id.String != "" && match(r) {
+			m[sqlKey{r.Inst_id, r.Sql_id.String, r.Sql_child_number.Int64}]++
+		}
+	})
+	var res []SqlidRow
+	for _, k := range ranked(m, n) {
+		sk := k.(sqlKey)
+		res = append(res, SqlidRow{
+			Inst_id:          sk.inst,
+			Sql_id:           sql.NullString{String: sk.sqlid, Valid: true},
+			Sql_child_number: sql.NullInt64{Int64: sk.child, Valid: true},
+			Seconds:          m[k],
+		})
+	}
+	return res
+}
+
+// topEvents is the TOP WAITS of the sessions match lets through
+func (s *sampler) topEvents(sc ashScope, n int, match func(r *SessionRecord) bool) []EventRow {
+	m := make(map[interface{}]int)
+	s.each(sc, func(t int64, r *SessionRecord) {
+		if match(r) {
+			m[eventKey{r.Event.String, r.Wait_class.String}]++
+		}
+	})
+	var res []EventRow
+	for _, k := range ranked(m, n) {
+		ek := k.(eventKey)
+		res = append(res, EventRow{
+			Event:      sql.NullString{String: ek.event, Valid: true},
+			Wait_class: sql.NullString{String: ek.class, Valid: ek.class != ""},
+			Seconds:    m[k],
+		})
+	}
+	return res
+}
+
+func anySession(r *SessionRecord) bool { return true }
+
+func (s *sampler) sampleErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *sampler) TopSqlids(sc ashScope) ([]SqlidRow, error) {
+	return s.topSqls(sc, sc.rows, anySession), s.sampleErr()
+}
+
+func (s *sampler) TopSids(sc ashScope) ([]SessionRow, error) {
+	m := make(map[interface{}]int)
+	s.each(sc, func(t int64, r *SessionRecord) {
+		m[sidKey{r.Inst_id, r.Sid, r.Serial}]++
+	})
+	var res []SessionRow
+	for _, k := range ranked(m, sc.rows) {
+		sk := k.(sidKey)
+		res = append(res, SessionRow{
+			Inst_id: sk.inst,
+			Sid:     sql.NullString{String: strconv.Itoa(sk.sid), Valid: true},
+			Serial:  sql.NullString{String: strconv.Itoa(sk.serial), Valid: true},
+			Seconds: m[k],
+		})
+	}
+	return res, s.sampleErr()
+}
+
+func (s *sampler) TopEvents(sc ashScope) ([]EventRow, error) {
+	return s.topEvents(sc, sc.rows, anySession), s.sampleErr()
+}
+
+func (s *sampler) Activity(sc ashScope, bucket int) ([]ActivityRow, error) {
+	type key struct {
+		bucket int64
+		class  string
+	}
+	m := make(map[key]int)
+	s.each(sc, func(t int64, r *SessionRecord) {
+		wc := r.Wait_class.String
+		if !r.Wait_class.Valid {
+			wc = "CPU"
+		}
+		m[key{t / int64(bucket) * int64(bucket), wc}]++
+	})
+	var res []ActivityRow
+	for k, n := range m {
+		res = append(res, ActivityRow{Bucket: k.bucket, Wait_class: k.class, Seconds: n})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Bucket < res[j].Bucket || res[i].Bucket == res[j].Bucket && res[i].Wait_class < res[j].Wait_class
+	})
+	return res, s.sampleErr()
+}
+
+func (s *sampler) EventSqls(event string, sc ashScope) ([]SqlidRow, error) {
+	return s.topSqls(sc, 20, func(r *SessionRecord) bool { return r.Event.String == event }), s.sampleErr()
+}
+
+// PlanLines has no time per line, gv$session doesn't show the plan line
+func (s *sampler) PlanLines(inst int, sqlid string, child int64, sc ashScope) ([]PlanRow, error) {
+	return getPlan(s.db, inst, sqlid, child)
+}
+
+// SqlText has no AWR fallback, AWR needs the Diagnostics Pack
+func (s *sampler) SqlText(inst int, sqlid string) (string, error) {
+	return getSqlFulltext(s.db, inst, sqlid, false)
+}
+
+func (s *sampler) SessionDetail(inst int, sid, serial string, sc ashScope) (SessionInfo, error) {
+	si, err := getSessionInfo(s.db, inst, sid, serial)
+	if err != nil {
+		return si, err
+	}
+	one := func(r *SessionRecord) bool {
+		return r.Inst_id == inst && strconv.Itoa(r.Sid) == sid && strconv.Itoa(r.Serial) == serial
+	}
+	sc.inst = 0
+	si.Events = s.topEvents(sc, 8, one)
+	si.Sqls = s.topSqls(sc, 8, one)
+	si.Waits, err = getWaitHistory(s.db, inst, sid)
+	return si, err
+}
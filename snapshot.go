@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// exit codes of the snapshot command
+const (
+	snapOK      = 0
+	snapFailed  = 1 // nothing collected
+	snapPartial = 2 // some panels failed, they are marked STALE
+)
+
+// snapshot collects one round and prints the dashboard as plain text
+func snapshot(c Collector, sc ashScope) int {
+	w, h := termSize()
+	S := layout(w, h, view{cluster: sc.inst == 0})
+	sc.rows = topRows(S)
+	showPanels(S)
+	fr := refresh(c, sc, frame{}, nil)
+	if fr.Cluster != (sc.inst == 0) {
+		S = layout(w, h, view{cluster: fr.Cluster})
+	}
+
+	cv := newCanvas(w, h)
+	out = cv
+	printTemplate(S)
+	printFrame(fr, selection{panel: -1}, S)
+	out = os.Stdout
+	fmt.Print(cv)
+
+	for _, p := range errPanels {
+		if e, ok := fr.Errs[p]; ok {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", p, e)
+		}
+	}
+	if len(fr.Errs) == 0 {
+		return snapOK
+	}
+	for _, p := range []string{"metrics", "topsqlids", "topsids", "events"} {
+		if _, ok := fr.Errs[p]; !ok {
+			return snapPartial
+		}
+	}
+	return snapFailed
+}
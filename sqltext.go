@@ -0,0 +1,488 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"unicode"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// getSqlFulltext reads SQL_FULLTEXT in pieces small enough for a varchar2.
+// A cursor aged out of the shared pool is looked up in AWR if awr is set.
+func getSqlFulltext(db *sqlx.DB, inst int, sqlid string, awr bool) (string, error) {
+	const pieces = `select dbms_lob.substr(t, 1000, 1+(n-1)*1000) from (%s),
+	(select level n from dual connect by level <= 1000)
+	where (n-1)*1000 < dbms_lob.getlength(t) order by n`
+	var parts []string
+	err := db.Select(&parts, fmt.Sprintf(pieces,
+		`select sql_fulltext t from gv$sql where sql_id = :1 and inst_id = :2 and rownum = 1`), sqlid, inst)
+	if err == nil && len(parts) == 0 && awr {
+		err = db.Select(&parts, fmt.Sprintf(pieces,
+			`select sql_text t from dba_hist_sqltext where sql_id = :1 and dbid = (select dbid from v$database)`), sqlid)
+	}
+	switch {
+	case err == nil && len(parts) == 0 && awr:
+		err = fmt.Errorf("%s is neither in the shared pool nor in AWR", sqlid)
+	case err == nil && len(parts) == 0:
+		err = fmt.Errorf("%s is not in the shared pool", sqlid)
+	}
+	return strings.Join(parts, ""), err
+}
+
+// token kinds of the SQL highlighter
+const (
+	tokWord = iota
+	tokKeyword
+	tokString
+	tokNumber
+	tokComment
+	tokPunct
+	tokSpace
+)
+
+type token struct {
+	kind int
+	s    string
+}
+
+var tokColors = map[int]int{
+	tokWord: 16, tokKeyword: 25, tokString: 28, tokNumber: 130, tokComment: 244, tokPunct: 16, tokSpace: 16,
+}
+
+func wordSet(s string) map[string]bool {
+	res := make(map[string]bool)
+	for _, w := range strings.Fields(s) {
+		res[w] = true
+	}
+	return res
+}
+
+var sqlKeywords = wordSet(`SELECT FROM WHERE AND OR NOT IN IS NULL LIKE BETWEEN EXISTS ANY SOME
+	GROUP BY ORDER HAVING UNION ALL INTERSECT MINUS INSERT INTO VALUES UPDATE SET DELETE MERGE
+	USING MATCHED WHEN THEN ELSE END CASE AS ON JOIN INNER LEFT RIGHT FULL OUTER CROSS NATURAL
+	WITH DISTINCT ASC DESC CONNECT START PRIOR NOCYCLE BEGIN DECLARE EXCEPTION FOR UPDATE OF
+	NOWAIT SKIP LOCKED LOOP IF ELSIF RETURN RETURNING FETCH FIRST NEXT ROWS ROW ONLY OFFSET
+	NULLS LAST OVER PARTITION ESCAPE TABLE CREATE ALTER DROP TRUNCATE COMMIT ROLLBACK
+	SAVEPOINT LOCK MODE PIVOT UNPIVOT SAMPLE LATERAL APPLY`)
+
+// clauses start a line at the indentation of their query block
+var sqlClauses = wordSet(`SELECT FROM WHERE GROUP ORDER HAVING UNION INTERSECT MINUS INSERT VALUES
+	UPDATE SET DELETE MERGE USING WHEN CONNECT START WITH RETURNING FETCH
+	LEFT RIGHT FULL INNER CROSS NATURAL JOIN`)
+
+// joinWords continue a clause: LEFT OUTER JOIN, START WITH, ...
+var joinWords = wordSet(`NATURAL LEFT RIGHT FULL INNER CROSS OUTER START FOR`)
+
+var multiPunct = wordSet(`<= >= <> != || := => ..`)
+
+func isWordRune(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '$' || c == '#'
+}
+
+// sqlTokens splits s into tokens, dropping the white space outside comments
+func sqlTokens(s string) []token {
+	var res []token
+	r := []rune(s)
+	for i := 0; i < len(r); {
+		c := r[i]
+		j := i + 1
+		kind := tokPunct
+		switch {
+		case unicode.IsSpace(c):
+			i++
+			continue
+		case c == '-' && j < len(r) && r[j] == '-':
+			for j < len(r) && r[j] != '\n' {
+				j++
+			}
+			kind = tokComment
+		case c == '/' && j < len(r) && r[j] == '*':
+			for j += 2; j < len(r) && !(r[j-1] == '*' && r[j] == '/'); j++ {
+			}
+			if j < len(r) {
+				j++
+			}
+			kind = tokComment
+		case c == '\'':
+			for ; j < len(r); j++ {
+				if r[j] == '\'' {
+					if j+1 < len(r) && r[j+1] == '\'' {
+						j++
+						continue
+					}
+					j++
+					break
+				}
+			}
+			kind = tokString
+		case c == '"':
+			for j < len(r) && r[j] != '"' {
+				j++
+			}
+			if j < len(r) {
+				j++
+			}
+			kind = tokWord
+		case unicode.IsDigit(c):
+			for j < len(r) && (unicode.IsDigit(r[j]) || r[j] == '.' && j+1 < len(r) && unicode.IsDigit(r[j+1])) {
+				j++
+			}
+			kind = tokNumber
+		case isWordRune(c) || c == ':' && j < len(r) && isWordRune(r[j]):
+			for j < len(r) && isWordRune(r[j]) {
+				j++
+			}
+			kind = tokWord
+			if sqlKeywords[strings.ToUpper(string(r[i:j]))] {
+				kind = tokKeyword
+			}
+		case j < len(r) && multiPunct[string(r[i:j+1])]:
+			j++
+		}
+		res = append(res, token{kind, string(r[i:j])})
+		i = j
+	}
+	return res
+}
+
+// formatSql lays out a statement one clause per line, subqueries and
+// AND/OR conditions indented. PL/SQL gets a line per statement.
+func formatSql(s string) [][]token {
+	var lines [][]token
+	var cur []token
+	level := 0       // indentation of the current query block
+	block := 0       // BEGIN ... END nesting
+	var parens []int // levels to return to at the closing parenthesis, -1 for the non query ones
+	cases, between, declared := 0, false, false
+	newline := func(indent int) {
+		if len(cur) > 1 {
+			lines = append(lines, cur)
+		}
+		if indent < 0 {
+			indent = 0
+		}
+		cur = []token{{tokSpace, strings.Repeat("  ", indent)}}
+	}
+	inQuery := func() bool {
+		return cases == 0 && (len(parens) == 0 || parens[len(parens)-1] >= 0)
+	}
+	add := func(t token) {
+		if last := cur[len(cur)-1]; len(cur) > 1 && last.s != "(" && last.s != "." && last.s != "@" &&
+			t.s != ")" && t.s != "," && t.s != ";" && t.s != "." && t.s != "@" &&
+			!(t.s == "(" && last.kind == tokWord) {
+			cur = append(cur, token{tokSpace, " "})
+		}
+		cur = append(cur, t)
+	}
+	newline(0)
+	toks := sqlTokens(s)
+	for i, t := range toks {
+		up := strings.ToUpper(t.s)
+		prev, next := "", ""
+		if i > 0 {
+			prev = strings.ToUpper(toks[i-1].s)
+		}
+		if i+1 < len(toks) {
+			next = strings.ToUpper(toks[i+1].s)
+		}
+		kw := t.kind == tokKeyword
+		switch {
+		case kw && up == "CASE":
+			add(t)
+			cases++
+			continue
+		case kw && up == "END" && (cases > 0 && next != "LOOP" && next != "IF"):
+			cases--
+		case kw && up == "END" && next != "LOOP" && next != "IF":
+			if block > 0 {
+				block--
+			}
+			level = block
+			newline(level)
+		case kw && (up == "BEGIN" || up == "DECLARE"):
+			if up == "BEGIN" && declared {
+				// the BEGIN of a DECLARE section is at the DECLARE
+				block--
+			}
+			declared = up == "DECLARE"
+			newline(block)
+			add(t)
+			block++
+			level = block
+			newline(level)
+			continue
+		case kw && up == "EXCEPTION":
+			newline(block - 1)
+			add(t)
+			newline(block)
+			continue
+		case kw && up == "BETWEEN":
+			between = true
+		case kw && (up == "AND" || up == "OR") && inQuery():
+			if between && up == "AND" {
+				between = false
+			} else {
+				newline(level + 1)
+			}
+		case kw && sqlClauses[up] && inQuery() && !joinWords[prev] && prev != "(":
+			newline(level)
+		case t.s == "(":
+			add(t)
+			if next == "SELECT" || next == "WITH" {
+				parens = append(parens, level)
+				level += 2
+				newline(level)
+			} else {
+				parens = append(parens, -1)
+			}
+			continue
+		case t.s == ")" && len(parens) > 0:
+			if l := parens[len(parens)-1]; l >= 0 {
+				level = l
+			}
+			parens = parens[:len(parens)-1]
+		}
+		add(t)
+		switch {
+		case t.kind == tokComment && strings.HasPrefix(t.s, "--"):
+			newline(level)
+		case t.s == "," && inQuery():
+			newline(level + 1)
+		case t.s == ";":
+			level, parens, cases = block, nil, 0
+			newline(level)
+		}
+	}
+	newline(0)
+	return breakLines(lines)
+}
+
+// breakLines splits the string literals and comments over several lines,
+// a line of the view is a line on the screen
+func breakLines(lines [][]token) [][]token {
+	var res [][]token
+	for _, l := range lines {
+		var cur []token
+		for _, t := range l {
+			for i, s := range strings.Split(t.s, "\n") {
+				if i > 0 {
+					res = append(res, cur)
+					cur = nil
+				}
+				if s != "" {
+					cur = append(cur, token{t.kind, s})
+				}
+			}
+		}
+		res = append(res, cur)
+	}
+	return res
+}
+
+// lineText is a formatted line without the colors
+func lineText(l []token) string {
+	var b strings.Builder
+	for _, t := range l {
+		b.WriteString(t.s)
+	}
+	return b.String()
+}
+
+// textView is a scrollable screen of highlighted lines
+type textView struct {
+	title     string
+	lines     [][]token
+	top, left int
+	note      string // shown instead of the help line once
+	help      string // the keys of the view, the scroll keys if empty
+}
+
+func newSqlView(title, sqltext string) *textView {
+	return &textView{title: title, lines: formatSql(sqltext)}
+}
+
+// newTextView shows lines of plain text
+func newTextView(title string, lines []string) *textView {
+	v := &textView{title: title}
+	v.setLines(lines)
+	return v
+}
+
+// setLines replaces the text of the view, the scroll position stays
+func (v *textView) setLines(lines []string) {
+	v.lines = nil
+	for _, l := range lines {
+		v.lines = append(v.lines, []token{{tokWord, l}})
+	}
+	v.lines = breakLines(v.lines)
+}
+
+// see scrolls line i into the page
+func (v *textView) see(i int, S map[string]F) {
+	if h := v.page(S); i >= v.top+h {
+		v.top = i - h + 1
+	}
+	if i < v.top {
+		v.top = i
+	}
+}
+
+// openSqlText shows the full text of the selected SQL_ID, nil if there is none
+func openSqlText(c Collector, fr frame, sel selection) *textView {
+	if sel.panel != panelSqlids || sel.row >= len(fr.Sqlids) || !fr.Sqlids[sel.row].Sql_id.Valid {
+		return nil
+	}
+	r := fr.Sqlids[sel.row]
+	title := fmt.Sprintf("SQL_FULLTEXT %s @%d", r.Sql_id.String, r.Inst_id)
+	text, err := c.SqlText(r.Inst_id, r.Sql_id.String)
+	if err != nil {
+		return newTextView(title, []string{err.Error()})
+	}
+	return newSqlView(title, text)
+}
+
+func (v *textView) text() string {
+	var b strings.Builder
+	for _, l := range v.lines {
+		b.WriteString(lineText(l))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// page is the number of lines shown
+func (v *textView) page(S map[string]F) int {
+	return S["screen"].h - 3
+}
+
+func (v *textView) draw(S map[string]F) {
+	scr := S["screen"]
+	h := v.page(S)
+	if v.top > len(v.lines)-h {
+		v.top = len(v.lines) - h
+	}
+	if v.top < 0 {
+		v.top = 0
+	}
+	fmt.Fprint(out, fg(16), bg(255), Cls, xy(1, 1))
+	fmt.Fprint(out, fg(17), BoldFont, v.title, fg(16))
+	for i := 0; i < h && v.top+i < len(v.lines); i++ {
+		fmt.Fprint(out, xy(1, i+3), v.render(v.lines[v.top+i], scr.w))
+	}
+	last := v.top + h
+	if last > len(v.lines) {
+		last = len(v.lines)
+	}
+	keys := v.help
+	if keys == "" {
+		keys = "arrows pgup pgdn scroll, p $PAGER, e $EDITOR, q returns"
+	}
+	help := fmt.Sprintf("%d-%d of %d lines, %s", v.top+1, last, len(v.lines), keys)
+	if v.note != "" {
+		help, v.note = v.note, ""
+	}
+	fmt.Fprint(out, xy(1, scr.h), fg(17), fitdots(help, scr.w-1), fg(16))
+}
+
+// render colors the part of l from the left scroll position which fits in w,
+// tabs and other control characters are shown as blanks
+func (v *textView) render(l []token, w int) string {
+	var b strings.Builder
+	skip := v.left
+	for _, t := range l {
+		r := []rune(t.s)
+		if skip >= len(r) {
+			skip -= len(r)
+			continue
+		}
+		r, skip = r[skip:], 0
+		if len(r) > w {
+			r = r[:w]
+		}
+		w -= len(r)
+		b.WriteString(fg(tokColors[t.kind]) + strings.Map(blankControl, string(r)))
+		if w == 0 {
+			break
+		}
+	}
+	return b.String() + fg(16)
+}
+
+func blankControl(r rune) rune {
+	if unicode.IsControl(r) {
+		return ' '
+	}
+	return r
+}
+
+// key handles a key press; it returns false for the keys which close the view
+func (v *textView) key(k string, S map[string]F) bool {
+	switch k {
+	case "up", "k":
+		v.top--
+	case "down", "j":
+		v.top++
+	case "pgup":
+		v.top -= v.page(S)
+	case "pgdn", " ":
+		v.top += v.page(S)
+	case "home":
+		v.top = 0
+	case "end":
+		v.top = len(v.lines)
+	case "left", "h":
+		if v.left -= 8; v.left < 0 {
+			v.left = 0
+		}
+	case "right", "l":
+		v.left += 8
+	case "p":
+		if err := v.external("PAGER", "less"); err != nil {
+			v.note = "$PAGER: " + err.Error()
+		}
+	case "e":
+		if err := v.external("EDITOR", "vi"); err != nil {
+			v.note = "$EDITOR: " + err.Error()
+		}
+	default:
+		return false
+	}
+	v.draw(S)
+	return true
+}
+
+// external hands the terminal to $PAGER or $EDITOR with the text in a temporary file
+func (v *textView) external(env, def string) error {
+	cmd := os.Getenv(env)
+	if cmd == "" {
+		cmd = def
+	}
+	f, err := ioutil.TempFile("", "oradash-*.sql")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(v.text())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	atomic.StoreInt32(&external, 1)
+	restoreTerm()
+	fmt.Fprint(out, Cls, xy(1, 1))
+	c := exec.Command("sh", "-c", cmd+` "$1"`, "sh", f.Name())
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	err = c.Run()
+	rawMode()
+	fmt.Fprint(out, "\x1b[?25l") // turn off cursor
+	atomic.StoreInt32(&external, 0)
+	return err
+}
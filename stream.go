@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// frameWriter writes refreshed frames for scripts instead of drawing them
+type frameWriter interface {
+	write(fr frame) error
+}
+
+// newFrameWriter writes frames as json or csv, the csv header only if header is set
+func newFrameWriter(format string, w io.Writer, header bool) (frameWriter, error) {
+	switch format {
+	case "json":
+		return jsonWriter{json.NewEncoder(w)}, nil
+	case "csv":
+		cw := csvWriter{csv.NewWriter(w)}
+		if !header {
+			return cw, nil
+		}
+		return cw, cw.header()
+	}
+	return nil, fmt.Errorf("unknown output format %q, use json or csv", format)
+}
+
+// jsonWriter writes a frame per line, the format of -record
+type jsonWriter struct {
+	enc *json.Encoder
+}
+
+func (jw jsonWriter) write(fr frame) error {
+	return jw.enc.Encode(fr)
+}
+
+// csvWriter writes a row per metric, top-N line, SQL text and error:
+// time,kind,inst_id,instance_name,key,detail,value. The metrics of several
+// instances have inst_id 0, only they have an instance_name.
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (cw csvWriter) header() error {
+	cw.w.Write([]string{"time", "kind", "inst_id", "instance_name", "key", "detail", "value"})
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+func (cw csvWriter) write(fr frame) error {
+	t := fr.Time.Format(time.RFC3339)
+	row := func(kind, inst, key, detail, value string) {
+		name := ""
+		if kind == "metric" {
+			name = fr.Metrics.iname
+		}
+		cw.w.Write([]string{t, kind, inst, name, key, detail, value})
+	}
+	num := func(v float32) string {
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	}
+	im := fr.Metrics
+	for _, m := range promMetrics {
+		row("metric", strconv.Itoa(im.inst), m.name, "", num(m.val(im)))
+	}
+	for _, r := range fr.Sqlids {
+		row("sqlid", strconv.Itoa(r.Inst_id), nstr(r.Sql_id), nint(r.Sql_child_number), strconv.Itoa(r.Seconds))
+	}
+	for _, r := range fr.Sids {
+		row("session", strconv.Itoa(r.Inst_id), nstr(r.Sid), nstr(r.Serial), strconv.Itoa(r.Seconds))
+	}
+	for _, r := range fr.Events {
+		row("event", "", nstr(r.Event), eventClass(r), strconv.Itoa(r.Seconds))
+	}
+	for _, r := range fr.Sqls {
+		row("sql", "", r.Sql_id, nint(r.Plan), r.Sqltext)
+	}
+	for _, p := range errPanels {
+		if e, ok := fr.Errs[p]; ok {
+			row("error", "", p, "", oneline(e))
+		}
+	}
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// stream refreshes every wait() and writes the frames until count frames
+// are written (0 for no limit) or a replay ends. An AWR range is written once.
+func stream(c Collector, rp *fixtureCollector, sc ashScope, fw frameWriter, wait func() time.Duration, count int) error {
+	var fr frame
+	for n := 1; ; n++ {
+		fr = refresh(c, sc, fr, nil)
+		if err := fw.write(fr); err != nil {
+			return err
+		}
+		if n == count || rp != nil && rp.done() || !sc.live() {
+			return nil
+		}
+		for _, e := range fr.Errs {
+			if isConnLost(e) {
+				if err := c.Reconnect(); err != nil {
+					logerr("reconnect: " + err.Error())
+				}
+				break
+			}
+		}
+		time.Sleep(wait())
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// the terminal state saved by rawMode, restored on every way out
+var (
+	ttyMu    sync.Mutex
+	ttySaved *unix.Termios
+)
+
+// external is 1 while another program ($PAGER, $EDITOR) owns the terminal
+var external int32
+
+// rawMode switches stdin to unbuffered, non-echoing input.
+// Signals are left on so ^C and ^Z arrive as SIGINT and SIGTSTP.
+func rawMode() error {
+	ttyMu.Lock()
+	defer ttyMu.Unlock()
+	fd := int(os.Stdin.Fd())
+	t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return err
+	}
+	saved := *t
+	t.Lflag &^= unix.ICANON | unix.ECHO
+	t.Iflag &^= unix.IXON
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+	if err = unix.IoctlSetTermios(fd, unix.TCSETS, t); err != nil {
+		return err
+	}
+	ttySaved = &saved
+	return nil
+}
+
+// restoreTerm puts the terminal back the way rawMode found it; safe to call more than once
+func restoreTerm() {
+	ttyMu.Lock()
+	defer ttyMu.Unlock()
+	if ttySaved == nil {
+		return
+	}
+	unix.IoctlSetTermios(int(os.Stdin.Fd()), unix.TCSETS, ttySaved)
+	ttySaved = nil
+	fmt.Print("\x1b[0m", "\x1b[?25h") // default colors, show cursor
+}
+
+// safe runs f in a goroutine; a panic there restores the terminal before crashing
+func safe(f func()) {
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				restoreTerm()
+				panic(r)
+			}
+		}()
+		f()
+	}()
+}
+
+// handleSignals restores the terminal on termination signals and handles
+// ^Z: the terminal is restored before stopping, raw mode is set again and
+// redraw is signalled after SIGCONT.
+func handleSignals(redraw chan<- struct{}) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTSTP, syscall.SIGCONT)
+	safe(func() {
+		for sig := range sigs {
+			switch sig {
+			case syscall.SIGTSTP:
+				restoreTerm()
+				fmt.Print(xy(1, termHeight()), "\n")
+				signal.Reset(syscall.SIGTSTP)
+				syscall.Kill(syscall.Getpid(), syscall.SIGTSTP)
+				// stopped here until SIGCONT
+				signal.Notify(sigs, syscall.SIGTSTP)
+			case syscall.SIGCONT:
+				if atomic.LoadInt32(&external) == 1 {
+					continue
+				}
+				rawMode()
+				fmt.Print("\x1b[?25l") // turn off cursor
+				select {
+				case redraw <- struct{}{}:
+				default:
+				}
+			case syscall.SIGINT:
+				if atomic.LoadInt32(&external) == 1 {
+					continue // it is for the other program
+				}
+				restoreTerm()
+				fmt.Print(xy(1, termHeight()), "\n")
+				os.Exit(128 + int(sig.(syscall.Signal)))
+			default:
+				restoreTerm()
+				fmt.Print(xy(1, termHeight()), "\n")
+				os.Exit(128 + int(sig.(syscall.Signal)))
+			}
+		}
+	})
+}
+
+// termSize returns the terminal size, falling back to the classic 111x23
+func termSize() (int, int) {
+	ws, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ)
+	if err != nil || ws.Col == 0 || ws.Row == 0 {
+		return 111, 23
+	}
+	return int(ws.Col), int(ws.Row)
+}
+
+func termHeight() int {
+	_, h := termSize()
+	return h
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// color depths of the terminal
+const (
+	depthNone = iota // NO_COLOR, only bold and reverse
+	depth16
+	depth256
+	depthTrue
+)
+
+var depth = depth256
+
+// A theme maps the xterm-256 colors the dashboard is drawn with, dark text
+// on white, to RGB. Colors missing from it keep their xterm RGB; on a
+// 256-color terminal the mapped ones go through c216.
+// The colors with a role: 16 text, 17 titles and labels, 255 background,
+// 25 sparklines and SQL keywords, 160 critical, 231 text on critical,
+// 208 warning, 28, 130 and 244 SQL strings, numbers and comments.
+var theme map[int]uint32
+
+var themes = map[string]map[int]uint32{
+	"light": nil,
+	"dark": {
+		16: 0xd0d0d0, 17: 0x87d7ff, 255: 0x1c1c1c, 25: 0x5fafff, 160: 0xff5f5f,
+		231: 0xffffff, 208: 0xffaf00, 28: 0x87d787, 130: 0xd7af5f, 244: 0x808080,
+	},
+	"solarized": {
+		16: 0x839496, 17: 0x268bd2, 255: 0x002b36, 25: 0x2aa198, 160: 0xdc322f,
+		231: 0xfdf6e3, 208: 0xcb4b16, 28: 0x859900, 130: 0xb58900, 244: 0x586e75,
+	},
+	"high-contrast": {
+		16: 0xffffff, 17: 0xffff00, 255: 0x000000, 25: 0x00ffff, 160: 0xff0000,
+		231: 0xffffff, 208: 0xff8700, 28: 0x00ff00, 130: 0xffaf00, 244: 0xc0c0c0,
+	},
+}
+
+func themeNames() string {
+	var names []string
+	for n := range themes {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// setTheme selects the theme and the color depth, "auto" looks at the environment
+func setTheme(name, colors string) error {
+	t, ok := themes[name]
+	if !ok {
+		return fmt.Errorf("unknown theme %q, use one of %s", name, themeNames())
+	}
+	theme = t
+	switch colors {
+	case "auto", "":
+		depth = colorDepth()
+	case "truecolor":
+		depth = depthTrue
+	case "256":
+		depth = depth256
+	case "16":
+		depth = depth16
+	case "none":
+		depth = depthNone
+	default:
+		return fmt.Errorf("unknown color depth %q, use auto, truecolor, 256, 16 or none", colors)
+	}
+	return nil
+}
+
+// colorDepth guesses what the terminal can show from the environment
+func colorDepth() int {
+	term, ct := os.Getenv("TERM"), os.Getenv("COLORTERM")
+	switch {
+	case os.Getenv("NO_COLOR") != "" || term == "dumb":
+		return depthNone
+	case ct == "truecolor" || ct == "24bit":
+		return depthTrue
+	case strings.Contains(term, "256"):
+		return depth256
+	}
+	return depth16
+}
+
+// color is the SGR sequence for the xterm color c in the theme, downgraded
+// to what the terminal shows; sgr is 38 for the foreground, 48 for the background
+func color(sgr, c int) string {
+	rgb, themed := theme[c]
+	if !themed {
+		rgb = xtermRGB(c)
+	}
+	r, g, b := int(rgb>>16), int(rgb>>8&0xff), int(rgb&0xff)
+	switch depth {
+	case depthNone:
+		return ""
+	case depthTrue:
+		return fmt.Sprintf("\x1b[%d;2;%d;%d;%dm", sgr, r, g, b)
+	case depth16:
+		n := nearest16(r, g, b)
+		if n >= 8 {
+			return fmt.Sprintf("\x1b[%dm", sgr+44+n) // 90-97, 100-107
+		}
+		return fmt.Sprintf("\x1b[%dm", sgr-8+n) // 30-37, 40-47
+	}
+	if themed {
+		c = c216(r, g, b)
+	}
+	return fmt.Sprintf("\x1b[%d;5;%dm", sgr, c)
+}
+
+// mark makes s stand out where NO_COLOR took its colors away
+func mark(s string) string {
+	return highlight(s, depth == depthNone)
+}
+
+// the xterm defaults of the 16 basic colors
+var ansi16 = []uint32{
+	0x000000, 0xcd0000, 0x00cd00, 0xcdcd00, 0x0000ee, 0xcd00cd, 0x00cdcd, 0xe5e5e5,
+	0x7f7f7f, 0xff0000, 0x00ff00, 0xffff00, 0x5c5cff, 0xff00ff, 0x00ffff, 0xffffff,
+}
+
+// the steps of the 6x6x6 color cube
+var cubeSteps = []int{0, 95, 135, 175, 215, 255}
+
+// xtermRGB is the RGB of an xterm-256 color
+func xtermRGB(c int) uint32 {
+	switch {
+	case c < 16:
+		return ansi16[c&15]
+	case c < 232:
+		c -= 16
+		return uint32(cubeSteps[c/36]<<16 | cubeSteps[c/6%6]<<8 | cubeSteps[c%6])
+	case c < 256:
+		v := uint32(8 + 10*(c-232))
+		return v<<16 | v<<8 | v
+	}
+	return 0
+}
+
+func dist(r, g, b int, rgb uint32) int {
+	dr, dg, db := r-int(rgb>>16), g-int(rgb>>8&0xff), b-int(rgb&0xff)
+	return dr*dr + dg*dg + db*db
+}
+
+func nearest16(r, g, b int) int {
+	best := 0
+	for i, rgb := range ansi16 {
+		if dist(r, g, b, rgb) < dist(r, g, b, ansi16[best]) {
+			best = i
+		}
+	}
+	return best
+}